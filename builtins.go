@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BUILTINS are looked up after every user defined function,
+// so a script is free to shadow any of them.
+var BUILTINS = map[string]*Function{
+	"len": {
+		name:   "len",
+		params: []string{"value"},
+		native: builtinLen,
+	},
+	"push": {
+		name:   "push",
+		params: []string{"array", "value"},
+		native: builtinPush,
+	},
+	"pop": {
+		name:   "pop",
+		params: []string{"array"},
+		native: builtinPop,
+	},
+	"keys": {
+		name:   "keys",
+		params: []string{"map"},
+		native: builtinKeys,
+	},
+	"has": {
+		name:   "has",
+		params: []string{"map", "key"},
+		native: builtinHas,
+	},
+	"delete": {
+		name:   "delete",
+		params: []string{"map", "key"},
+		native: builtinDelete,
+	},
+	"int": {
+		name:   "int",
+		params: []string{"value"},
+		native: builtinInt,
+	},
+	"float": {
+		name:   "float",
+		params: []string{"value"},
+		native: builtinFloat,
+	},
+	"str": {
+		name:   "str",
+		params: []string{"value"},
+		native: builtinStr,
+	},
+}
+
+func builtinLen(pos Pos, args []Type) (Type, error) {
+	value := args[0]
+	switch value.kind {
+	case TYPE_ARRAY:
+		return newInt(int64(len(value.as.array.items))), nil
+	case TYPE_STRING:
+		return newInt(int64(utf8.RuneCountInString(value.as.str))), nil
+	case TYPE_MAP:
+		return newInt(int64(len(value.as.dict.keys))), nil
+	}
+	return Type{}, typeErrorf(pos, "len: expected array, map or string, got '%s'", value)
+}
+
+// builtinPush appends value to the end of the array
+// and returns the array itself.
+func builtinPush(pos Pos, args []Type) (Type, error) {
+	array := args[0]
+	if array.kind != TYPE_ARRAY {
+		return Type{}, typeErrorf(pos, "push: expected array, got '%s'", array)
+	}
+	array.as.array.items = append(array.as.array.items, args[1])
+	return array, nil
+}
+
+// builtinPop removes the last item of the array and returns it.
+func builtinPop(pos Pos, args []Type) (Type, error) {
+	array := args[0]
+	if array.kind != TYPE_ARRAY {
+		return Type{}, typeErrorf(pos, "pop: expected array, got '%s'", array)
+	}
+	items := array.as.array.items
+	if len(items) == 0 {
+		return Type{}, runtimeErrorf(pos, "pop: array is empty")
+	}
+	last := items[len(items)-1]
+	array.as.array.items = items[:len(items)-1]
+	return last, nil
+}
+
+// builtinKeys returns the keys of the map in insertion order.
+func builtinKeys(pos Pos, args []Type) (Type, error) {
+	m := args[0]
+	if m.kind != TYPE_MAP {
+		return Type{}, typeErrorf(pos, "keys: expected map, got '%s'", m)
+	}
+	keys := make([]Type, len(m.as.dict.keys))
+	copy(keys, m.as.dict.keys)
+	return newArray(keys), nil
+}
+
+func builtinHas(pos Pos, args []Type) (Type, error) {
+	m := args[0]
+	if m.kind != TYPE_MAP {
+		return Type{}, typeErrorf(pos, "has: expected map, got '%s'", m)
+	}
+	_, ok, err := m.as.dict.Get(args[1])
+	if err != nil {
+		return Type{}, typeErrorf(pos, "has: %s", err)
+	}
+	return newBool(ok), nil
+}
+
+// builtinDelete removes the key from the map,
+// returning whether the key was present.
+func builtinDelete(pos Pos, args []Type) (Type, error) {
+	m := args[0]
+	if m.kind != TYPE_MAP {
+		return Type{}, typeErrorf(pos, "delete: expected map, got '%s'", m)
+	}
+	ok, err := m.as.dict.Delete(args[1])
+	if err != nil {
+		return Type{}, typeErrorf(pos, "delete: %s", err)
+	}
+	return newBool(ok), nil
+}
+
+// builtinInt converts a number or a string to an int,
+// floats are truncated towards zero.
+func builtinInt(pos Pos, args []Type) (Type, error) {
+	value := args[0]
+	switch value.kind {
+	case TYPE_INT:
+		return value, nil
+	case TYPE_FLOAT:
+		f := value.as.float
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return Type{}, runtimeErrorf(pos, "int: cannot convert '%s' to an int", value)
+		}
+		n, _ := big.NewFloat(f).Int(nil)
+		return newBigInt(n), nil
+	case TYPE_STRING:
+		n, ok := new(big.Int).SetString(strings.TrimSpace(value.as.str), 10)
+		if !ok {
+			return Type{}, runtimeErrorf(pos, "int: cannot convert %s to an int", value.quoted())
+		}
+		return newBigInt(n), nil
+	}
+	return Type{}, typeErrorf(pos, "int: expected number or string, got '%s'", value)
+}
+
+func builtinFloat(pos Pos, args []Type) (Type, error) {
+	value := args[0]
+	switch value.kind {
+	case TYPE_INT, TYPE_FLOAT:
+		return newFloat(toFloat(value)), nil
+	case TYPE_STRING:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value.as.str), 64)
+		if err != nil {
+			return Type{}, runtimeErrorf(pos, "float: cannot convert %s to a float", value.quoted())
+		}
+		return newFloat(f), nil
+	}
+	return Type{}, typeErrorf(pos, "float: expected number or string, got '%s'", value)
+}
+
+// builtinStr converts any value to a string, the same way print shows it.
+func builtinStr(pos Pos, args []Type) (Type, error) {
+	return newStr(args[0].String()), nil
+}
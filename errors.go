@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseError is a syntax error found by the tokenizer or the parser.
+type ParseError struct {
+	pos Pos
+	msg string
+}
+
+func (e ParseError) Error() string {
+	return e.msg
+}
+
+func (e ParseError) Pos() Pos {
+	return e.pos
+}
+
+// RuntimeError is an error found while evaluating the code,
+// like an index out of bounds or an unknown variable.
+type RuntimeError struct {
+	pos Pos
+	msg string
+}
+
+func (e RuntimeError) Error() string {
+	return e.msg
+}
+
+func (e RuntimeError) Pos() Pos {
+	return e.pos
+}
+
+// TypeError is an error found while evaluating the code
+// when a value has the wrong type, like adding a number to an array.
+type TypeError struct {
+	pos Pos
+	msg string
+}
+
+func (e TypeError) Error() string {
+	return e.msg
+}
+
+func (e TypeError) Pos() Pos {
+	return e.pos
+}
+
+// TracedError is an error that happened inside a function call,
+// along with the calls that were active at the time, innermost first.
+type TracedError struct {
+	err   error
+	trace []Frame
+}
+
+func (e *TracedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *TracedError) Unwrap() error {
+	return e.err
+}
+
+// ReturnSignal is not an actual error, it carries the value of a 'return'
+// through every expression being evaluated up to the enclosing function call,
+// which stops it. It is only reported when there is no function to return from.
+type ReturnSignal struct {
+	value Type
+	pos   Pos
+}
+
+func (r ReturnSignal) Error() string {
+	return "'return' outside of a function"
+}
+
+func (r ReturnSignal) Pos() Pos {
+	return r.pos
+}
+
+// BreakSignal carries the value of a 'break' up to the enclosing loop,
+// like ReturnSignal does for functions.
+type BreakSignal struct {
+	value     Type
+	has_value bool
+	pos       Pos
+}
+
+func (b BreakSignal) Error() string {
+	return "'break' outside of a loop"
+}
+
+func (b BreakSignal) Pos() Pos {
+	return b.pos
+}
+
+// ContinueSignal skips the rest of the body of the enclosing loop.
+type ContinueSignal struct {
+	pos Pos
+}
+
+func (c ContinueSignal) Error() string {
+	return "'continue' outside of a loop"
+}
+
+func (c ContinueSignal) Pos() Pos {
+	return c.pos
+}
+
+// MAX_TRACE_FRAMES is how many frames of a stack trace are printed,
+// the frames in the middle of deeper traces are omitted.
+const MAX_TRACE_FRAMES = 20
+
+func runtimeErrorf(pos Pos, format string, args ...any) error {
+	return RuntimeError{pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func typeErrorf(pos Pos, format string, args ...any) error {
+	return TypeError{pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+// Source is the code being interpreted, kept around to report errors.
+type Source struct {
+	name string
+	text string
+}
+
+// Report formats err as 'file:line:col: message', followed by the
+// offending source line with a caret under the error position.
+// Errors without a position are reported with just the file name,
+// and joined errors are reported one after the other.
+func (src Source) Report(err error) string {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		out := strings.Builder{}
+		for _, err := range joined.Unwrap() {
+			out.WriteString(src.Report(err))
+		}
+		return out.String()
+	}
+
+	var positioned interface{ Pos() Pos }
+	if !errors.As(err, &positioned) {
+		return fmt.Sprintf("%s: ERROR: %s\n", src.name, err)
+	}
+	pos := positioned.Pos()
+
+	label := "ERROR"
+	switch {
+	case errors.As(err, new(ParseError)):
+		label = "PARSE ERROR"
+	case errors.As(err, new(TypeError)):
+		label = "TYPE ERROR"
+	}
+
+	out := strings.Builder{}
+	fmt.Fprintf(&out, "%s:%d:%d: %s: %s\n", src.name, pos.Line, pos.Col, label, err)
+
+	line := src.line(pos)
+	gutter := fmt.Sprintf("%d", pos.Line)
+	fmt.Fprintf(&out, " %s | %s\n", gutter, line)
+	fmt.Fprintf(&out, " %s | %s^\n", strings.Repeat(" ", len(gutter)), src.caretPadding(line, pos.Col))
+
+	var traced *TracedError
+	if errors.As(err, &traced) {
+		out.WriteString(src.Trace(traced.trace))
+	}
+	return out.String()
+}
+
+// Trace formats the frames of a stack trace, innermost call first.
+// Only the first and last frames of deep traces are kept.
+func (src Source) Trace(trace []Frame) string {
+	out := strings.Builder{}
+	out.WriteString("stack trace (innermost call first):\n")
+	for i, frame := range trace {
+		if len(trace) > MAX_TRACE_FRAMES && i == MAX_TRACE_FRAMES/2 {
+			fmt.Fprintf(&out, "    ... %d frames omitted ...\n", len(trace)-MAX_TRACE_FRAMES)
+		}
+		if len(trace) > MAX_TRACE_FRAMES && i >= MAX_TRACE_FRAMES/2 && i < len(trace)-MAX_TRACE_FRAMES/2 {
+			continue
+		}
+		fmt.Fprintf(&out, "    at %s, called from %s:%s\n", frame, src.name, frame.call_site)
+	}
+	return out.String()
+}
+
+// line returns the source line containing pos, without the line break.
+func (src Source) line(pos Pos) string {
+	start := min(pos.Offset, len(src.text))
+	for start > 0 && src.text[start-1] != '\n' {
+		start--
+	}
+	end := start
+	for end < len(src.text) && src.text[end] != '\n' {
+		end++
+	}
+	return strings.TrimRight(src.text[start:end], "\r")
+}
+
+// caretPadding keeps the tabs of the source line,
+// so the caret lines up with the column no matter the tab width.
+// Columns are counted in runes.
+func (src Source) caretPadding(line string, col int) string {
+	out := strings.Builder{}
+	i := 0
+	for _, char := range line {
+		if i >= col-1 {
+			break
+		}
+		if char == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+		i++
+	}
+	return out.String()
+}
@@ -2,127 +2,610 @@ package main
 
 import (
 	"bufio"
+	"cmp"
+	"errors"
 	"flag"
 	"fmt"
-	"maps"
+	"iter"
+	"math"
+	"math/big"
 	"os"
+	"slices"
 	"strings"
 )
 
 const DEBUG = false
 
-func (exp Number) Eval(env *Env) Type {
-	return newFloat(float64(exp))
+// MAX_CALL_DEPTH limits the recursion of xpr functions,
+// so a runaway recursion is reported instead of crashing the interpreter.
+const MAX_CALL_DEPTH = 10_000
+
+// Frame is a function call being evaluated.
+type Frame struct {
+	name      string
+	params    []string
+	args      []Type
+	call_site Pos
 }
 
-func (s String) Eval(env *Env) Type {
-	return newStr(string(s))
+type CallStack struct {
+	frames []Frame
 }
 
-func (op BinOp) Eval(env *Env) Type {
-	right_ := op.right.Eval(env)
+func (f Frame) String() string {
+	args := []string{}
+	for i, param := range f.params {
+		args = append(args, fmt.Sprintf("%s = %s", param, f.args[i].quoted()))
+	}
+	return fmt.Sprintf("%s(%s)", f.name, strings.Join(args, ", "))
+}
 
-	if op.op.Type == EQUAL {
-		var_, ok := op.left.(Var)
-		if !ok {
-			fmt.Printf("ERROR: invalid variable for assignment: '%s'\n", op.left)
-			os.Exit(1)
+// callStack returns the call stack kept by the root environment.
+func (env *Env) callStack() *CallStack {
+	for env.parent != nil {
+		env = env.parent
+	}
+	return env.stack
+}
+
+func (stack *CallStack) push(frame Frame) error {
+	if len(stack.frames) >= MAX_CALL_DEPTH {
+		return runtimeErrorf(frame.call_site, "stack overflow: more than %d nested function calls", MAX_CALL_DEPTH)
+	}
+	stack.frames = append(stack.frames, frame)
+	return nil
+}
+
+func (stack *CallStack) pop() {
+	stack.frames = stack.frames[:len(stack.frames)-1]
+}
+
+// trace returns a copy of the frames, innermost call first.
+func (stack *CallStack) trace() []Frame {
+	trace := make([]Frame, 0, len(stack.frames))
+	for i := len(stack.frames) - 1; i >= 0; i-- {
+		trace = append(trace, stack.frames[i])
+	}
+	return trace
+}
+
+func (exp Number) Eval(env *Env) (Type, error) {
+	return exp.value, nil
+}
+
+func (s String) Eval(env *Env) (Type, error) {
+	return newStr(s.value), nil
+}
+
+func (b Boolean) Eval(env *Env) (Type, error) {
+	return newBool(b.value), nil
+}
+
+func (op BinOp) Eval(env *Env) (Type, error) {
+	switch op.op.Type {
+	case EQUAL:
+		right_, err := op.right.Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		switch left := op.left.(type) {
+		case Var:
+			if !env.assign(left.name, right_) {
+				return Type{}, runtimeErrorf(left.Pos(), "assignment to undeclared variable '%s', declare it with 'let %s = ...'", left.name, left.name)
+			}
+		case Index:
+			err = left.assign(env, right_)
+			if err != nil {
+				return Type{}, err
+			}
+		default:
+			return Type{}, runtimeErrorf(op.left.Pos(), "invalid variable for assignment: '%s'", op.left)
 		}
-		env.vars[var_] = right_
-		return right_
+		return right_, nil
+	case AND_AND, OR_OR:
+		return op.evalLogical(env)
+	case PLUS_EQUAL, MINUS_EQUAL, MULT_EQUAL, DIV_EQUAL, MOD_EQUAL:
+		return op.evalCompound(env)
 	}
 
-	left_ := op.left.Eval(env)
-	if left_.kind != TYPE_FLOAT {
-		fmt.Printf("ERROR: invalid operand for binary operator: '%s'\n", op.left)
-		os.Exit(1)
+	left_, err := op.left.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	right_, err := op.right.Eval(env)
+	if err != nil {
+		return Type{}, err
 	}
+	return op.apply(left_, right_)
+}
 
-	if right_.kind != TYPE_FLOAT {
-		fmt.Printf("ERROR: invalid operand for binary operator: '%s'\n", op.left)
-		os.Exit(1)
+// apply applies the operator to the values of both operands.
+func (op BinOp) apply(left_ Type, right_ Type) (Type, error) {
+	switch op.op.Type {
+	case EQUAL_EQUAL:
+		return newBool(equal(left_, right_)), nil
+	case BANG_EQUAL:
+		return newBool(!equal(left_, right_)), nil
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+		return op.compare(left_, right_)
+	case PLUS:
+		if left_.kind == TYPE_STRING && right_.kind == TYPE_STRING {
+			return newStr(left_.as.str + right_.as.str), nil
+		}
+	case MULT:
+		if left_.kind == TYPE_STRING && isNumber(right_) {
+			return op.repeat(left_, right_, op.right)
+		}
+		if isNumber(left_) && right_.kind == TYPE_STRING {
+			return op.repeat(right_, left_, op.left)
+		}
+	}
+
+	if !isNumber(left_) {
+		return Type{}, typeErrorf(op.left.Pos(), "invalid operand for binary operator: '%s'", op.left)
+	}
+
+	if !isNumber(right_) {
+		return Type{}, typeErrorf(op.right.Pos(), "invalid operand for binary operator: '%s'", op.right)
+	}
+
+	switch op.op.Type {
+	case DOT_DOT, DOT_DOT_EQUAL:
+		return op.rangeOf(left_, right_)
+	case DIV:
+		// '/' always results in a float, use '~/' for an int
+	default:
+		if left_.kind == TYPE_INT && right_.kind == TYPE_INT {
+			return op.applyInt(left_, right_)
+		}
 	}
 
-	left := left_.as.float
-	right := right_.as.float
+	left := toFloat(left_)
+	right := toFloat(right_)
 
 	switch op.op.Type {
 	case PLUS:
-		return newFloat(left + right)
+		return newFloat(left + right), nil
 	case MINUS:
-		return newFloat(left - right)
+		return newFloat(left - right), nil
 	case MULT:
-		return newFloat(left * right)
+		return newFloat(left * right), nil
 	case DIV:
-		return newFloat(left / right)
-	case GREATER:
-		res := left > right
-		if res {
-			return newFloat(1.0)
-		} else {
-			return newFloat(0.0)
+		if right == 0 {
+			return Type{}, runtimeErrorf(op.Pos(), "division by zero")
 		}
-	case GREATER_EQUAL:
-		res := left >= right
-		if res {
-			return newFloat(1.0)
-		} else {
-			return newFloat(0.0)
+		return newFloat(left / right), nil
+	case INT_DIV:
+		if right == 0 {
+			return Type{}, runtimeErrorf(op.Pos(), "division by zero")
 		}
-	case LESS:
-		res := left < right
-		if res {
-			return newFloat(1.0)
-		} else {
-			return newFloat(0.0)
-		}
-	case LESS_EQUAL:
-		res := left <= right
-		if res {
-			return newFloat(1.0)
-		} else {
-			return newFloat(0.0)
+		return newFloat(math.Floor(left / right)), nil
+	case MOD:
+		if right == 0 {
+			return Type{}, runtimeErrorf(op.Pos(), "modulo by zero")
 		}
-	case EQUAL_EQUAL:
-		res := left == right
-		if res {
-			return newFloat(1.0)
-		} else {
-			return newFloat(0.0)
+		return newFloat(floorMod(left, right)), nil
+	case POW:
+		// a negative power is a division, '0 ** -1' is '1 / 0'
+		if left == 0 && right < 0 {
+			return Type{}, runtimeErrorf(op.Pos(), "division by zero")
+		}
+		return newFloat(math.Pow(left, right)), nil
+	}
+	return Type{}, runtimeErrorf(op.Pos(), "invalid binary operator: '%s'", op.op.Value)
+}
+
+// applyInt applies the operator to two ints, the result is an int
+// except for negative powers, like '2 ** -1', which are floats.
+// Results that do not fit in an int64 are promoted to a big int.
+func (op BinOp) applyInt(left_ Type, right_ Type) (Type, error) {
+	// big ints are never zero, see newBigInt
+	if right_.as.big == nil && right_.as.int == 0 {
+		switch op.op.Type {
+		case INT_DIV:
+			return Type{}, runtimeErrorf(op.Pos(), "division by zero")
+		case MOD:
+			return Type{}, runtimeErrorf(op.Pos(), "modulo by zero")
+		}
+	}
+	if left_.as.big == nil && right_.as.big == nil {
+		if res, ok := op.applySmallInt(left_.as.int, right_.as.int); ok {
+			return res, nil
+		}
+	}
+	return op.applyBigInt(toBig(left_), toBig(right_))
+}
+
+// applySmallInt applies the operator to two int64s,
+// reporting false when the result overflows.
+func (op BinOp) applySmallInt(left int64, right int64) (Type, bool) {
+	switch op.op.Type {
+	case PLUS:
+		res := left + right
+		return newInt(res), (left^res)&(right^res) >= 0
+	case MINUS:
+		res := left - right
+		return newInt(res), (left^right)&(left^res) >= 0
+	case MULT:
+		res := left * right
+		overflow := left != 0 && (res/left != right || (left == -1 && right == math.MinInt64))
+		return newInt(res), !overflow
+	case INT_DIV:
+		if left == math.MinInt64 && right == -1 {
+			return Type{}, false
+		}
+		res := left / right
+		if left%right != 0 && (left < 0) != (right < 0) {
+			res--
+		}
+		return newInt(res), true
+	case MOD:
+		res := left % right
+		if res != 0 && (res < 0) != (right < 0) {
+			res += right
+		}
+		return newInt(res), true
+	}
+	// powers are computed by applyBigInt
+	return Type{}, false
+}
+
+func (op BinOp) applyBigInt(left *big.Int, right *big.Int) (Type, error) {
+	switch op.op.Type {
+	case PLUS:
+		return newBigInt(new(big.Int).Add(left, right)), nil
+	case MINUS:
+		return newBigInt(new(big.Int).Sub(left, right)), nil
+	case MULT:
+		return newBigInt(new(big.Int).Mul(left, right)), nil
+	case INT_DIV, MOD:
+		// QuoRem truncates, the results are adjusted to floor like applySmallInt
+		quo, rem := new(big.Int).QuoRem(left, right, new(big.Int))
+		if rem.Sign() != 0 && (rem.Sign() < 0) != (right.Sign() < 0) {
+			quo.Sub(quo, big.NewInt(1))
+			rem.Add(rem, right)
+		}
+		if op.op.Type == MOD {
+			return newBigInt(rem), nil
+		}
+		return newBigInt(quo), nil
+	case POW:
+		if right.Sign() < 0 {
+			if left.Sign() == 0 {
+				return Type{}, runtimeErrorf(op.Pos(), "division by zero")
+			}
+			return newFloat(math.Pow(bigToFloat(left), bigToFloat(right))), nil
 		}
+		return newBigInt(new(big.Int).Exp(left, right, nil)), nil
+	}
+	return Type{}, runtimeErrorf(op.Pos(), "invalid binary operator: '%s'", op.op.Value)
+}
+
+// rangeOf builds the range 'start..end' or 'start..=end',
+// both bounds must be integers.
+func (op BinOp) rangeOf(start Type, end Type) (Type, error) {
+	start_int, ok := asInt(start)
+	if !ok {
+		return Type{}, typeErrorf(op.left.Pos(), "range bounds must be integers, got '%s'", start)
+	}
+	end_int, ok := asInt(end)
+	if !ok {
+		return Type{}, typeErrorf(op.right.Pos(), "range bounds must be integers, got '%s'", end)
+	}
+	return newRange(start_int, end_int, op.op.Type == DOT_DOT_EQUAL), nil
+}
+
+// MAX_STRING_LENGTH is the largest string, in bytes, a repetition can build.
+const MAX_STRING_LENGTH = 1 << 28
+
+// repeat repeats str count times, count being a non negative integer.
+func (op BinOp) repeat(str Type, count Type, count_expr Expr) (Type, error) {
+	n, ok := asInt(count)
+	// a big int count is checked by its sign, and then by the length
+	if !ok && count.kind == TYPE_INT {
+		n, ok = int64(count.as.big.Sign())*math.MaxInt64, true
+	}
+	if !ok {
+		return Type{}, typeErrorf(count_expr.Pos(), "cannot repeat a string '%s' times: expected an integer number", count)
+	}
+	if n < 0 {
+		return Type{}, runtimeErrorf(count_expr.Pos(), "cannot repeat a string a negative number of times: %s", count)
+	}
+	if len(str.as.str) > 0 && n > MAX_STRING_LENGTH/int64(len(str.as.str)) {
+		return Type{}, runtimeErrorf(op.Pos(), "cannot repeat a string %s times: the result would be longer than %d bytes", count, MAX_STRING_LENGTH)
+	}
+	return newStr(strings.Repeat(str.as.str, int(n))), nil
+}
+
+// compare orders two numbers or two strings, strings are compared
+// lexicographically by code point. Any other operands are a type error.
+func (op BinOp) compare(left Type, right Type) (Type, error) {
+	var order int
+	switch {
+	case isNumber(left) && isNumber(right):
+		var ordered bool
+		order, ordered = compareNumbers(left, right)
+		// NaN is not ordered, every comparison with it is false
+		if !ordered {
+			return newBool(false), nil
+		}
+	case left.kind == TYPE_STRING && right.kind == TYPE_STRING:
+		order = strings.Compare(left.as.str, right.as.str)
 	default:
-		return newFloat(0.0)
+		return Type{}, typeErrorf(op.Pos(), "cannot compare %s '%s' with %s '%s' using '%s'", left.kind, op.left, right.kind, op.right, op.op.Value)
+	}
+
+	switch op.op.Type {
+	case GREATER:
+		return newBool(order > 0), nil
+	case GREATER_EQUAL:
+		return newBool(order >= 0), nil
+	case LESS:
+		return newBool(order < 0), nil
 	}
+	return newBool(order <= 0), nil
 }
 
-func (unop UnOp) Eval(env *Env) Type {
-	right := unop.right.Eval(env)
-	if right.kind != TYPE_FLOAT {
-		fmt.Printf("ERROR: invalid operand for unary operator '%s'\n", unop.right)
-		os.Exit(1)
+// equal compares values of any kind, values of different kinds are never equal,
+// except for ints and floats, which are compared by their value.
+// Arrays and maps are equal when their items are equal, regardless of the
+// order of the keys of a map. Functions are only equal to themselves.
+func equal(left Type, right Type) bool {
+	return equalIn(left, right, map[[2]any]bool{})
+}
+
+// equalIn compares left and right, pairs holds the arrays and maps
+// already being compared. A pair met again is assumed to be equal, so arrays
+// and maps containing themselves are compared without recursing forever.
+func equalIn(left Type, right Type, pairs map[[2]any]bool) bool {
+	if left.kind != right.kind {
+		if isNumber(left) && isNumber(right) {
+			order, ordered := compareNumbers(left, right)
+			return ordered && order == 0
+		}
+		return false
 	}
-	res := Type{
-		kind: TYPE_FLOAT,
+	switch left.kind {
+	case TYPE_FLOAT:
+		return left.as.float == right.as.float
+	case TYPE_INT:
+		return compareInts(left, right) == 0
+	case TYPE_STRING:
+		return left.as.str == right.as.str
+	case TYPE_BOOL:
+		return left.as.boolean == right.as.boolean
+	case TYPE_RANGE:
+		return left.as.rng == right.as.rng
+	case TYPE_FUNCTION:
+		return left.as.fun == right.as.fun
+	case TYPE_ARRAY:
+		pair := [2]any{left.as.array, right.as.array}
+		if left.as.array == right.as.array || pairs[pair] {
+			return true
+		}
+		pairs[pair] = true
+		return slices.EqualFunc(left.as.array.items, right.as.array.items, func(left Type, right Type) bool {
+			return equalIn(left, right, pairs)
+		})
+	case TYPE_MAP:
+		pair := [2]any{left.as.dict, right.as.dict}
+		if left.as.dict == right.as.dict || pairs[pair] {
+			return true
+		}
+		pairs[pair] = true
+		if len(left.as.dict.keys) != len(right.as.dict.keys) {
+			return false
+		}
+		for _, key := range left.as.dict.keys {
+			left_val, _, _ := left.as.dict.Get(key)
+			right_val, ok, _ := right.as.dict.Get(key)
+			if !ok || !equalIn(left_val, right_val, pairs) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// floorMod is the remainder of a floored division, it has the sign of
+// the divisor, so that 'a == (a ~/ b) * b + a % b' holds.
+func floorMod(left float64, right float64) float64 {
+	res := math.Mod(left, right)
+	if res != 0 && (res < 0) != (right < 0) {
+		res += right
+	}
+	return res
+}
+
+func isNumber(val Type) bool {
+	return val.kind == TYPE_INT || val.kind == TYPE_FLOAT
+}
+
+// toFloat converts an int or a float to a float64,
+// big ints that are too large become infinities.
+func toFloat(val Type) float64 {
+	if val.kind != TYPE_INT {
+		return val.as.float
+	}
+	if val.as.big != nil {
+		return bigToFloat(val.as.big)
+	}
+	return float64(val.as.int)
+}
+
+func bigToFloat(b *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(b).Float64()
+	return f
+}
+
+// toBig converts an int to a big.Int, which must not be changed.
+func toBig(val Type) *big.Int {
+	if val.as.big != nil {
+		return val.as.big
+	}
+	return big.NewInt(val.as.int)
+}
+
+// compareNumbers orders two numbers by their exact value, so an int
+// is never rounded to compare it with a float.
+// It reports false when either number is NaN, which has no order.
+func compareNumbers(left Type, right Type) (int, bool) {
+	if left.kind == TYPE_INT && right.kind == TYPE_INT {
+		return compareInts(left, right), true
+	}
+	if math.IsNaN(toFloat(left)) || math.IsNaN(toFloat(right)) {
+		return 0, false
+	}
+	return toBigFloat(left).Cmp(toBigFloat(right)), true
+}
+
+// toBigFloat converts a number, which must not be NaN, to a big.Float
+// holding its exact value.
+func toBigFloat(val Type) *big.Float {
+	if val.kind == TYPE_FLOAT {
+		return big.NewFloat(val.as.float)
+	}
+	return new(big.Float).SetInt(toBig(val))
+}
+
+func compareInts(left Type, right Type) int {
+	if left.as.big == nil && right.as.big == nil {
+		return cmp.Compare(left.as.int, right.as.int)
 	}
+	return toBig(left).Cmp(toBig(right))
+}
+
+// asInt converts an int, or a float without a fractional part, to an int64.
+// It is used wherever an integer is expected, like indices and range bounds,
+// so big ints are rejected.
+func asInt(val Type) (int64, bool) {
+	switch val.kind {
+	case TYPE_INT:
+		return val.as.int, val.as.big == nil
+	case TYPE_FLOAT:
+		f := val.as.float
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), true
+		}
+	}
+	return 0, false
+}
+
+// COMPOUND_OPS are the operators applied by each compound assignment.
+var COMPOUND_OPS = map[TokenType]TokenType{
+	PLUS_EQUAL:  PLUS,
+	MINUS_EQUAL: MINUS,
+	MULT_EQUAL:  MULT,
+	DIV_EQUAL:   DIV,
+	MOD_EQUAL:   MOD,
+}
+
+// evalCompound evaluates assignments like 'a += b' as 'a = a + b',
+// but evaluating the target (and the index of an indexed target) only once.
+func (op BinOp) evalCompound(env *Env) (Type, error) {
+	arith := BinOp{
+		left:  op.left,
+		right: op.right,
+		op:    Token{Type: COMPOUND_OPS[op.op.Type], Value: strings.TrimSuffix(op.op.Value, "="), Pos: op.op.Pos},
+	}
+
+	switch left := op.left.(type) {
+	case Var:
+		current, err := left.Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		right, err := op.right.Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		res, err := arith.apply(current, right)
+		if err != nil {
+			return Type{}, err
+		}
+		env.assign(left.name, res)
+		return res, nil
+	case Index:
+		target, index, err := left.operands(env)
+		if err != nil {
+			return Type{}, err
+		}
+		current, err := left.get(target, index)
+		if err != nil {
+			return Type{}, err
+		}
+		right, err := op.right.Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		res, err := arith.apply(current, right)
+		if err != nil {
+			return Type{}, err
+		}
+		return res, left.set(target, index, res)
+	}
+	return Type{}, runtimeErrorf(op.left.Pos(), "invalid variable for assignment: '%s'", op.left)
+}
+
+// evalLogical evaluates '&&' and '||', only evaluating the right
+// operand when the left one does not already decide the result.
+func (op BinOp) evalLogical(env *Env) (Type, error) {
+	left, err := op.left.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	if left.kind != TYPE_BOOL {
+		return Type{}, typeErrorf(op.left.Pos(), "invalid operand for logical operator: '%s'", op.left)
+	}
+	if op.op.Type == AND_AND && !left.as.boolean {
+		return left, nil
+	}
+	if op.op.Type == OR_OR && left.as.boolean {
+		return left, nil
+	}
+
+	right, err := op.right.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	if right.kind != TYPE_BOOL {
+		return Type{}, typeErrorf(op.right.Pos(), "invalid operand for logical operator: '%s'", op.right)
+	}
+	return right, nil
+}
+
+func (unop UnOp) Eval(env *Env) (Type, error) {
+	right, err := unop.right.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	if unop.op.Type == BANG {
+		if right.kind != TYPE_BOOL {
+			return Type{}, typeErrorf(unop.right.Pos(), "invalid operand for unary operator '%s'", unop.right)
+		}
+		return newBool(!right.as.boolean), nil
+	}
+
+	if !isNumber(right) {
+		return Type{}, typeErrorf(unop.right.Pos(), "invalid operand for unary operator '%s'", unop.right)
+	}
+	res := right
 	switch unop.op.Type {
 	case PLUS:
-		res.as.float = +right.as.float
-		return res
+		return res, nil
 	case MINUS:
+		if right.kind == TYPE_INT && (right.as.big != nil || right.as.int == math.MinInt64) {
+			return newBigInt(new(big.Int).Neg(toBig(right))), nil
+		}
 		res.as.float = -right.as.float
-		return res
+		res.as.int = -right.as.int
+		return res, nil
 	}
-	fmt.Printf("ERROR: invalid unary operator: %s\n", unop)
-	os.Exit(1)
-	return Type{}
+	return Type{}, runtimeErrorf(unop.Pos(), "invalid unary operator: %s", unop)
 }
 
-func (v Var) Eval(env *Env) Type {
-	if env == nil {
-		fmt.Printf("ERROR: unknown variable '%s'\n", v)
-		os.Exit(1)
-	}
-
+func (v Var) Eval(env *Env) (Type, error) {
 	if DEBUG {
 		fmt.Printf("----\n")
 		fmt.Printf("Evaluating Var '%s'\n", v)
@@ -130,39 +613,227 @@ func (v Var) Eval(env *Env) Type {
 		fmt.Printf("Env: %#v\n", env)
 	}
 
-	val, ok := env.vars[v]
+	val, ok := env.get(v.name)
+	if ok {
+		return val, nil
+	}
+	if builtin, ok := BUILTINS[v.name]; ok {
+		return newFunction(builtin), nil
+	}
+	return Type{}, runtimeErrorf(v.Pos(), "unknown variable '%s'", v)
+}
+
+func (ts ToStr) Eval(env *Env) (Type, error) {
+	val, err := ts.expr.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	return newStr(val.String()), nil
+}
+
+func (l Let) Eval(env *Env) (Type, error) {
+	val, err := l.value.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	env.vars[l.name] = val
+	return val, nil
+}
+
+func (arr ArrayLit) Eval(env *Env) (Type, error) {
+	items := make([]Type, 0, len(arr.items))
+	for _, item := range arr.items {
+		val, err := item.Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		items = append(items, val)
+	}
+	return newArray(items), nil
+}
+
+func (m MapLit) Eval(env *Env) (Type, error) {
+	res := newMap()
+	for i := range m.keys {
+		key, err := m.keys[i].Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		val, err := m.values[i].Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		err = res.as.dict.Set(key, val)
+		if err != nil {
+			return Type{}, typeErrorf(m.keys[i].Pos(), "%s", err)
+		}
+	}
+	return res, nil
+}
+
+func (idx Index) Eval(env *Env) (Type, error) {
+	target, index, err := idx.operands(env)
+	if err != nil {
+		return Type{}, err
+	}
+	return idx.get(target, index)
+}
+
+func (idx Index) assign(env *Env, val Type) error {
+	target, index, err := idx.operands(env)
+	if err != nil {
+		return err
+	}
+	return idx.set(target, index, val)
+}
+
+// operands evaluates the indexed value and the index.
+func (idx Index) operands(env *Env) (Type, Type, error) {
+	target, err := idx.target.Eval(env)
+	if err != nil {
+		return Type{}, Type{}, err
+	}
+	index, err := idx.index.Eval(env)
+	if err != nil {
+		return Type{}, Type{}, err
+	}
+	return target, index, nil
+}
+
+func (idx Index) get(target Type, index Type) (Type, error) {
+	switch target.kind {
+	case TYPE_ARRAY:
+		i, err := idx.arrayIndex(target, index)
+		if err != nil {
+			return Type{}, err
+		}
+		return target.as.array.items[i], nil
+	case TYPE_MAP:
+		val, ok, err := target.as.dict.Get(index)
+		if err != nil {
+			return Type{}, typeErrorf(idx.index.Pos(), "%s", err)
+		}
+		if !ok {
+			return Type{}, runtimeErrorf(idx.index.Pos(), "key not found: %s", index.quoted())
+		}
+		return val, nil
+	case TYPE_STRING:
+		return idx.strIndex(target, index)
+	}
+	return Type{}, typeErrorf(idx.target.Pos(), "cannot index into '%s'", idx.target)
+}
+
+func (idx Index) set(target Type, index Type, val Type) error {
+	switch target.kind {
+	case TYPE_ARRAY:
+		i, err := idx.arrayIndex(target, index)
+		if err != nil {
+			return err
+		}
+		target.as.array.items[i] = val
+		return nil
+	case TYPE_MAP:
+		err := target.as.dict.Set(index, val)
+		if err != nil {
+			return typeErrorf(idx.index.Pos(), "%s", err)
+		}
+		return nil
+	case TYPE_STRING:
+		return typeErrorf(idx.target.Pos(), "cannot assign into '%s': strings cannot be changed", idx.target)
+	}
+	return typeErrorf(idx.target.Pos(), "cannot index into '%s'", idx.target)
+}
+
+// arrayIndex checks that index is an integer inside the array bounds.
+func (idx Index) arrayIndex(array Type, index Type) (int, error) {
+	return idx.position(index, len(array.as.array.items))
+}
+
+// position checks that index is an integer inside the bounds of a sequence.
+func (idx Index) position(index Type, length int) (int, error) {
+	if index.kind == TYPE_INT && index.as.big != nil {
+		return 0, runtimeErrorf(idx.index.Pos(), "index out of bounds: the length is %d but the index is %s", length, index)
+	}
+	i, ok := asInt(index)
 	if !ok {
-		return v.Eval(env.parent)
+		return 0, typeErrorf(idx.index.Pos(), "invalid index '%s': expected an integer number", index)
 	}
-	return val
+	if i < 0 || i >= int64(length) {
+		return 0, runtimeErrorf(idx.index.Pos(), "index out of bounds: the length is %d but the index is %d", length, i)
+	}
+	return int(i), nil
 }
 
-func (block Block) Eval(_ *Env) Type {
+// strIndex indexes a string by code point, with either a number
+// for a single character or a range for a substring.
+func (idx Index) strIndex(str Type, index Type) (Type, error) {
+	chars := []rune(str.as.str)
+	if index.kind != TYPE_RANGE {
+		i, err := idx.position(index, len(chars))
+		if err != nil {
+			return Type{}, err
+		}
+		return newStr(string(chars[i])), nil
+	}
+
+	rng := index.as.rng
+	start, end := rng.start, rng.end
+	if rng.inclusive {
+		end++
+	}
+	if start < 0 || end > int64(len(chars)) || start > end {
+		return Type{}, runtimeErrorf(idx.index.Pos(), "slice out of bounds: the length is %d but the slice is %s", len(chars), index)
+	}
+	return newStr(string(chars[start:end])), nil
+}
+
+// Eval evaluates the block in a new scope inside env.
+func (block Block) Eval(env *Env) (Type, error) {
+	scope := newEnv()
+	scope.parent = env
+	return block.evalIn(&scope)
+}
+
+// evalIn evaluates the block directly in scope, for the callers
+// that need to bind some variables in the scope of the block.
+func (block Block) evalIn(scope *Env) (Type, error) {
 	var res Type
 
 	if DEBUG {
 		fmt.Printf("-----\n")
 		fmt.Printf("Block:\n%#v\n", block)
-		fmt.Printf("Env:\n%#v\n", block.env)
+		fmt.Printf("Env:\n%#v\n", scope)
 	}
 
 	for _, expr := range block.exprs {
-		res = expr.Eval(block.env)
-		if res.is_return {
-			break
+		var err error
+		res, err = expr.Eval(scope)
+		if err != nil {
+			return Type{}, err
 		}
+	}
+	return res, nil
+}
 
+// evalCond evaluates the condition of an if or a while,
+// which must be a boolean.
+func evalCond(cond Expr, env *Env, what string) (bool, error) {
+	res, err := cond.Eval(env)
+	if err != nil {
+		return false, err
 	}
-	return res
+	if res.kind != TYPE_BOOL {
+		return false, typeErrorf(cond.Pos(), "invalid condition in %s: '%s'", what, cond)
+	}
+	return res.as.boolean, nil
 }
 
-func (i If) Eval(env *Env) Type {
-	cond := i.cond.Eval(env)
-	if cond.kind != TYPE_FLOAT {
-		fmt.Printf("ERROR: invalid condition in while: '%s'\n", i.cond)
-		os.Exit(1)
+func (i If) Eval(env *Env) (Type, error) {
+	cond, err := evalCond(i.cond, env, "if")
+	if err != nil {
+		return Type{}, err
 	}
-	if cond.as.float > 0.0 {
+	if cond {
 		return i.then.Eval(env)
 	}
 	return Type{
@@ -170,95 +841,250 @@ func (i If) Eval(env *Env) Type {
 		as: As{
 			float: 0.0,
 		},
-	}
+	}, nil
 }
 
-func (ie IfElse) Eval(env *Env) Type {
-	cond := ie.cond.Eval(env)
-	if cond.kind != TYPE_FLOAT {
-		fmt.Printf("ERROR: invalid condition in while: '%s'\n", ie.cond)
-		os.Exit(1)
+func (ie IfElse) Eval(env *Env) (Type, error) {
+	cond, err := evalCond(ie.cond, env, "if")
+	if err != nil {
+		return Type{}, err
 	}
-	if cond.as.float > 0.0 {
+	if cond {
 		return ie.then.Eval(env)
 	} else {
 		return ie.elze.Eval(env)
 	}
 }
 
-func (w While) Eval(env *Env) Type {
+// loopStep takes the result of an iteration of a loop's body,
+// stopping the loop on a 'break'. prev is the value of the last
+// iteration, which is kept by a 'continue' or a 'break' without a value.
+func loopStep(prev Type, res Type, err error) (Type, bool, error) {
+	switch signal := err.(type) {
+	case nil:
+		return res, false, nil
+	case ContinueSignal:
+		return prev, false, nil
+	case BreakSignal:
+		if signal.has_value {
+			return signal.value, true, nil
+		}
+		return prev, true, nil
+	}
+	return Type{}, true, err
+}
+
+func (w While) Eval(env *Env) (Type, error) {
 	res := Type{}
-	cond := w.cond.Eval(env)
-	if cond.kind != TYPE_FLOAT {
-		fmt.Printf("ERROR: invalid condition in while: '%s'\n", w.cond)
-		os.Exit(1)
-	}
-	for cond.as.float > 0.0 {
-		res = w.then.Eval(env)
-		cond = w.cond.Eval(env)
-		if cond.kind != TYPE_FLOAT {
-			fmt.Printf("ERROR: invalid condition in while: '%s'\n", w.cond)
-			os.Exit(1)
+	cond, err := evalCond(w.cond, env, "while")
+	if err != nil {
+		return Type{}, err
+	}
+	for cond {
+		body, err := w.then.Eval(env)
+		var stop bool
+		res, stop, err = loopStep(res, body, err)
+		if err != nil || stop {
+			return res, err
+		}
+		cond, err = evalCond(w.cond, env, "while")
+		if err != nil {
+			return Type{}, err
 		}
 	}
-	return res
+	return res, nil
 }
 
-func (fc FunctionCall) Eval(_ *Env) Type {
-	prev_env := fc.fun.body.env
+func (f For) Eval(env *Env) (Type, error) {
+	iter, err := f.iter.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	items, err := f.items(iter)
+	if err != nil {
+		return Type{}, err
+	}
+
+	res := Type{}
+	for item := range items {
+		scope := newEnv()
+		scope.parent = env
+		scope.vars[f.name] = item
+		body, err := f.then.evalIn(&scope)
+		var stop bool
+		res, stop, err = loopStep(res, body, err)
+		if err != nil || stop {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// items returns the values the loop goes through,
+// an integer n goes through the range 0..n.
+// Arrays and maps are copied, so the body is free to change them.
+func (f For) items(value Type) (iter.Seq[Type], error) {
+	switch value.kind {
+	case TYPE_INT, TYPE_FLOAT:
+		n, ok := asInt(value)
+		if !ok {
+			return nil, typeErrorf(f.iter.Pos(), "for: cannot iterate over '%s': expected an integer number", value)
+		}
+		return rangeItems(Range{start: 0, end: n}), nil
+	case TYPE_RANGE:
+		return rangeItems(value.as.rng), nil
+	case TYPE_ARRAY:
+		return slices.Values(slices.Clone(value.as.array.items)), nil
+	case TYPE_MAP:
+		return slices.Values(slices.Clone(value.as.dict.keys)), nil
+	case TYPE_STRING:
+		return func(yield func(Type) bool) {
+			for _, char := range value.as.str {
+				if !yield(newStr(string(char))) {
+					return
+				}
+			}
+		}, nil
+	}
+	return nil, typeErrorf(f.iter.Pos(), "for: cannot iterate over '%s'", value)
+}
+
+func rangeItems(rng Range) iter.Seq[Type] {
+	return func(yield func(Type) bool) {
+		for i := rng.start; i < rng.end; i++ {
+			if !yield(newInt(i)) {
+				return
+			}
+		}
+		// checked apart so an end of math.MaxInt64 does not overflow
+		if rng.inclusive && rng.start <= rng.end {
+			yield(newInt(rng.end))
+		}
+	}
+}
+
+func (fc FunctionCall) Eval(env *Env) (Type, error) {
+	callee, err := fc.callee.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	if callee.kind != TYPE_FUNCTION {
+		return Type{}, typeErrorf(fc.callee.Pos(), "'%s' is not a function, but '%s'", fc.callee, callee)
+	}
+	fun := callee.as.fun
 
-	env := newEnv()
-    maps.Copy(env.vars, prev_env.vars)
-    maps.Copy(env.funcs, prev_env.funcs)
-	env.parent = prev_env.parent
-	fc.fun.body.env = &env
+	// every parameter must be passed, a missing one would otherwise
+	// be looked up in the scope the function was declared in
+	if len(fc.args) < len(fun.params) {
+		return Type{}, runtimeErrorf(fc.pos, "%s: missing argument '%s'", fun.displayName(), fun.params[len(fc.args)])
+	}
+	if len(fc.args) > len(fun.params) {
+		return Type{}, runtimeErrorf(fc.pos, "%s: expected %d arguments, but got %d", fun.displayName(), len(fun.params), len(fc.args))
+	}
+	args := make([]Type, 0, len(fc.args))
+	for _, arg := range fc.args {
+		val, err := arg.Eval(env)
+		if err != nil {
+			return Type{}, err
+		}
+		args = append(args, val)
+	}
 
-	for arg, val := range fc.args {
-		eval := val.Eval(fc.fun.body.env)
-		env.vars[arg] = eval
+	if fun.native != nil {
+		return fun.native(fc.pos, args)
 	}
 
-	for _, expr := range fc.fun.body.exprs {
-		updateParent(expr, &env)
+	call_env := newEnv()
+	call_env.parent = fun.env
+
+	frame := Frame{name: fun.displayName(), call_site: fc.pos}
+	for i, arg := range args {
+		call_env.vars[fun.params[i]] = arg
+		frame.params = append(frame.params, fun.params[i])
+		frame.args = append(frame.args, arg)
+	}
+	stack := env.callStack()
+	err = stack.push(frame)
+	if err != nil {
+		return Type{}, err
 	}
+	defer stack.pop()
 
 	if DEBUG {
 		fmt.Printf("---\n")
-		fmt.Printf("Prev Env Addr: %p\n", prev_env)
-		fmt.Printf("Curr Env Addr: %p\n", &env)
-		fmt.Printf("Curr Env     : %+v\n", env)
+		fmt.Printf("Curr Env Addr: %p\n", &call_env)
+		fmt.Printf("Curr Env     : %+v\n", call_env)
 		fmt.Printf("Function Call:\n%+v\n", fc)
 	}
 
-	res := fc.fun.body.Eval(nil)
+	res, err := fun.body.Eval(&call_env)
+	if ret, ok := err.(ReturnSignal); ok {
+		return ret.value, nil
+	}
 
-	fc.fun.body.env = prev_env
-	for _, expr := range fc.fun.body.exprs {
-		updateParent(expr, prev_env)
+	// the innermost call is the first to see the error,
+	// when the whole stack is still there to be traced
+	if err != nil && !errors.As(err, new(*TracedError)) {
+		err = &TracedError{err: err, trace: stack.trace()}
 	}
+	return res, err
+}
 
-	return res
+// Eval creates a closure, a function value capturing the scope
+// it is declared in. Named functions are also declared as variables
+// holding the closure in that scope.
+func (fd FunctionDecl) Eval(env *Env) (Type, error) {
+	closure := *fd.fun
+	closure.env = env
+	fun := newFunction(&closure)
+	if fd.fun.name != "" {
+		env.vars[fd.fun.name] = fun
+	}
+	return fun, nil
 }
 
-func (p Print) Eval(env *Env) Type {
-	res := p.expr.Eval(env)
+func (b Break) Eval(env *Env) (Type, error) {
+	if b.expr == nil {
+		return Type{}, BreakSignal{pos: b.pos}
+	}
+	res, err := b.expr.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	return Type{}, BreakSignal{value: res, has_value: true, pos: b.pos}
+}
+
+func (c Continue) Eval(env *Env) (Type, error) {
+	return Type{}, ContinueSignal{pos: c.pos}
+}
+
+func (p Print) Eval(env *Env) (Type, error) {
+	res, err := p.expr.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
 	fmt.Printf("%s", res)
-	return res
+	return res, nil
 }
 
-func (r Return) Eval(env *Env) Type {
-	res := r.expr.Eval(env)
-	res.is_return = true
-	return res
+func (r Return) Eval(env *Env) (Type, error) {
+	res, err := r.expr.Eval(env)
+	if err != nil {
+		return Type{}, err
+	}
+	return Type{}, ReturnSignal{value: res, pos: r.pos}
 }
 
-func interpret_file(parser *Parser, input_file string) {
+// interpret_file runs the code in input_file.
+// The returned error has already been reported.
+func interpret_file(parser *Parser, input_file string) error {
 	code, err := os.ReadFile(input_file)
 	if err != nil {
-		fmt.Printf("ERROR: could not read file '%s': %s", input_file, err)
-		return
+		fmt.Printf("ERROR: could not read file '%s': %s\n", input_file, err)
+		return err
 	}
 
+	parser.source = Source{name: input_file, text: string(code)}
 	tokenizer := NewTokenizer(string(code))
 	tokens, err := tokenizer.Scan()
 	if DEBUG {
@@ -268,28 +1094,37 @@ func interpret_file(parser *Parser, input_file string) {
 	}
 
 	if err != nil {
-		fmt.Printf("ERROR: Tokenizer: %s\n", err)
-		return
-		// os.Exit(1)
+		fmt.Print(parser.source.Report(err))
+		return err
 	}
 
 	parser.ResetTokens(tokens)
-	main_block := parser.Parse()
-	_ = main_block.Eval(nil)
+	main_block, err := parser.Parse()
+	if err != nil {
+		fmt.Print(parser.source.Report(err))
+		return err
+	}
+
+	_, err = main_block.Eval(parser.env)
+	if err != nil {
+		fmt.Print(parser.source.Report(err))
+		return err
+	}
 	// fmt.Printf("%s\n", main_block)
-	// for name, fn := range main_block.(Block).env.funcs {
-	// 	fmt.Printf("%s = \n%+v\n", name, *fn)
-	// }
 	// fmt.Printf("Final Value: %s\n", res)
+	return nil
 }
 
 func REPL(parser *Parser) {
+	scan := bufio.NewScanner(os.Stdin)
 	for {
-		scan := bufio.NewScanner(os.Stdin)
 		fmt.Printf(">>> ")
 		line := ""
 		blocks := 0
-		scan.Scan()
+		if !scan.Scan() {
+			fmt.Println()
+			return
+		}
 		txt := scan.Text()
 		if strings.Contains(txt, "{") {
 			blocks++
@@ -298,8 +1133,7 @@ func REPL(parser *Parser) {
 			blocks--
 		}
 		line += txt
-		for blocks > 0 {
-			scan.Scan()
+		for blocks > 0 && scan.Scan() {
 			txt := scan.Text()
 			if strings.Contains(txt, "{") {
 				blocks++
@@ -307,20 +1141,36 @@ func REPL(parser *Parser) {
 			if strings.Contains(txt, "}") {
 				blocks--
 			}
-			line += txt
+			line += "\n" + txt
 		}
 
+		parser.source = Source{name: "<repl>", text: line}
 		tokenizer := NewTokenizer(line)
 		tokens, err := tokenizer.Scan()
 
 		if err != nil {
-			fmt.Printf("ERROR: Tokenizer: %s\n", err)
+			fmt.Print(parser.source.Report(err))
 			continue
 		}
 
 		parser.ResetTokens(tokens)
-		expr := parser.Expression(0)
-		res := expr.Eval(parser.env)
+		expr, err := parser.Parse()
+		if err != nil {
+			fmt.Print(parser.source.Report(err))
+			continue
+		}
+		block := expr.(Block)
+		if len(block.exprs) == 0 {
+			continue
+		}
+
+		// evaluated in the root scope, so the variables declared
+		// on a line are still there on the next ones
+		res, err := block.evalIn(parser.env)
+		if err != nil {
+			fmt.Print(parser.source.Report(err))
+			continue
+		}
 		fmt.Printf("%s\n", res)
 	}
 }
@@ -332,7 +1182,10 @@ func main() {
 	parser := NewParser(tokens)
 
 	if *input != "" {
-		interpret_file(&parser, *input)
+		err := interpret_file(&parser, *input)
+		if err != nil {
+			os.Exit(1)
+		}
 		return
 	}
 
@@ -345,44 +1198,3 @@ func endsWith(s string, pattern byte) bool {
 	}
 	return s[len(s)-1] == pattern
 }
-
-func updateParent(expr Expr, parent *Env) {
-	switch expr := expr.(type) {
-	case BinOp:
-		updateParent(expr.left, parent)
-		updateParent(expr.right, parent)
-		return
-
-	case UnOp:
-		updateParent(expr.right, parent)
-		return
-
-	case Block:
-		expr.env.parent = parent
-		return
-
-	case If:
-		expr.then.env.parent = parent
-		return
-
-	case IfElse:
-		expr.then.env.parent = parent
-		expr.elze.env.parent = parent
-		return
-
-	case While:
-		expr.then.env.parent = parent
-		return
-
-	case Print:
-		updateParent(expr.expr, parent)
-		return
-
-	case Return:
-		updateParent(expr.expr, parent)
-		return
-	default:
-		return
-	}
-
-}
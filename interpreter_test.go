@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run interprets code and returns what it printed.
+func run(t *testing.T, code string) (string, error) {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, reader)
+		output <- buf.String()
+	}()
+
+	err = interpret(code)
+	writer.Close()
+	return <-output, err
+}
+
+func interpret(code string) error {
+	tokenizer := NewTokenizer(code)
+	tokens, err := tokenizer.Scan()
+	if err != nil {
+		return err
+	}
+	parser := NewParser(tokens)
+	main_block, err := parser.Parse()
+	if err != nil {
+		return err
+	}
+	_, err = main_block.Eval(parser.env)
+	return err
+}
+
+// expectedOutput reads the '// Expected output:' comment of an example,
+// reporting false when it has none.
+func expectedOutput(code string) (string, bool) {
+	_, comment, ok := strings.Cut(code, "// Expected output:\n")
+	if !ok {
+		return "", false
+	}
+	out := strings.Builder{}
+	for _, line := range strings.Split(comment, "\n") {
+		text, ok := strings.CutPrefix(line, "// ")
+		if !ok {
+			break
+		}
+		out.WriteString(text + "\n")
+	}
+	return out.String(), true
+}
+
+func TestReturn(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "from a while loop",
+			code: `
+				fun first_above(limit) {
+					let i = 0;
+					while true {
+						i += 1;
+						if i > limit { return i; }
+					}
+				}
+				print first_above(10);`,
+			want: "11",
+		},
+		{
+			name: "from a for loop",
+			code: `
+				fun index_of(items, item) {
+					for i in 0..len(items) {
+						if items[i] == item { return i; }
+					}
+					return -1;
+				}
+				print "{index_of([4, 5, 6], 6)} {index_of([4, 5, 6], 7)}";`,
+			want: "2 -1",
+		},
+		{
+			name: "from nested blocks",
+			code: `
+				fun sign(n) {
+					{
+						if n < 0 {
+							{ return "negative"; }
+						}
+					}
+					"positive";
+				}
+				print "{sign(-3)} {sign(3)}";`,
+			want: "negative positive",
+		},
+		{
+			name: "the caller block goes on",
+			code: `
+				fun one() { return 1; print "unreachable"; }
+				let total = 0;
+				{
+					total += one();
+					total += one();
+				}
+				print "total = {total}";`,
+			want: "total = 2",
+		},
+		{
+			name: "recursive fibonacci",
+			code: `
+				fun fib(n) {
+					if n == 0 { return 0; }
+					if n == 1 { return 1; }
+					fib(n - 1) + fib(n - 2);
+				}
+				print fib(10);`,
+			want: "55",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := run(t, test.code)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReturnOutsideFunction(t *testing.T) {
+	_, err := run(t, "return 1;")
+	if _, ok := err.(ReturnSignal); !ok {
+		t.Fatalf("got %v, want a 'return' outside of a function error", err)
+	}
+}
+
+// TestExamples runs every example with an expected output comment.
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob("examples/*.xpr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			code, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, ok := expectedOutput(string(code))
+			if !ok {
+				t.Skip("no expected output")
+			}
+			got, err := run(t, string(code))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
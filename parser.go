@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
@@ -11,40 +13,128 @@ type Parser struct {
 	tokens []Token
 	cursor int
 	env    *Env
+	source Source
+
+	// syntax errors found so far, parsing goes on after an error
+	// so that all of them can be reported at once
+	errors []error
+
+	// how many loops enclose the code being parsed,
+	// 'break' and 'continue' are only valid inside of one
+	loop_depth int
 }
 
 type TypeKind int
 
 const (
 	TYPE_FLOAT TypeKind = iota
+	TYPE_INT
 	TYPE_STRING
+	TYPE_BOOL
+	TYPE_ARRAY
+	TYPE_MAP
+	TYPE_RANGE
+	TYPE_FUNCTION
 )
 
+func (kind TypeKind) String() string {
+	switch kind {
+	case TYPE_FLOAT:
+		return "float"
+	case TYPE_INT:
+		return "int"
+	case TYPE_STRING:
+		return "string"
+	case TYPE_BOOL:
+		return "bool"
+	case TYPE_ARRAY:
+		return "array"
+	case TYPE_MAP:
+		return "map"
+	case TYPE_RANGE:
+		return "range"
+	case TYPE_FUNCTION:
+		return "function"
+	}
+	return "?????"
+}
+
 type As struct {
-	float float64
-	str   string
+	float   float64
+	int     int64
+	big     *big.Int
+	str     string
+	boolean bool
+	array   *Array
+	dict    *Map
+	rng     Range
+	fun     *Function
+}
+
+// Range goes from start up to end, stepping by 1,
+// end is only part of the range when it is inclusive.
+type Range struct {
+	start     int64
+	end       int64
+	inclusive bool
+}
+
+// Array is shared between every Type that holds it,
+// so pushing into an array is visible through all its references.
+type Array struct {
+	items []Type
+}
+
+// Map keeps its keys in insertion order,
+// so iterating and printing a map is deterministic.
+type Map struct {
+	keys   []Type
+	values map[mapKey]Type
+}
+
+type mapKey struct {
+	kind    TypeKind
+	float   float64
+	int     int64
+	str     string
+	boolean bool
 }
 
 type Type struct {
-	kind      TypeKind
-	as        As
-	is_return bool
+	kind TypeKind
+	as   As
 }
 
 type Expr interface {
-	Eval(env *Env) Type
+	Eval(env *Env) (Type, error)
 	String() string
+	Pos() Pos
 }
 
+// Env is a scope, holding the variables declared in it.
 type Env struct {
-	vars   map[Var]Type
-	funcs  map[string]*Function
+	vars   map[string]Type
 	parent *Env
+
+	// only set on the root environment, see Env.callStack
+	stack *CallStack
+}
+
+// Number holds either an int or a float value.
+type Number struct {
+	value Type
+	pos   Pos
 }
 
-type Number float64
+type String struct {
+	value string
+	pos   Pos
+}
 
-type String string
+type Boolean struct {
+	value bool
+	pos   Pos
+}
 
 type BinOp struct {
 	left  Expr
@@ -57,11 +147,44 @@ type UnOp struct {
 	op    Token
 }
 
-type Var string
+// ToStr converts the value of an interpolated expression to a string.
+type ToStr struct {
+	expr Expr
+}
+
+type Var struct {
+	name string
+	pos  Pos
+}
+
+// Let declares a variable in the current scope,
+// shadowing any variable with the same name.
+type Let struct {
+	name  string
+	value Expr
+	pos   Pos
+}
 
 type Block struct {
-	exprs    []Expr
-	env      *Env
+	exprs []Expr
+	pos   Pos
+}
+
+type ArrayLit struct {
+	items []Expr
+	pos   Pos
+}
+
+type MapLit struct {
+	keys   []Expr
+	values []Expr
+	pos    Pos
+}
+
+type Index struct {
+	target Expr
+	index  Expr
+	pos    Pos
 }
 
 type Assignment struct {
@@ -72,6 +195,7 @@ type Assignment struct {
 type If struct {
 	cond Expr
 	then Block
+	pos  Pos
 }
 
 type IfElse struct {
@@ -82,25 +206,58 @@ type IfElse struct {
 type While struct {
 	cond Expr
 	then Block
+	pos  Pos
+}
+
+// For goes through an array, a string, the keys of a map or a range,
+// binding each item to name. 'for range x' binds the items to 'it'.
+type For struct {
+	name string
+	iter Expr
+	then Block
+	pos  Pos
 }
 
 type Print struct {
 	expr Expr
+	pos  Pos
 }
 
 type Function struct {
 	name   string
-	params []Var
-	body   Block
+	params []string
+	body   Expr
+	native func(pos Pos, args []Type) (Type, error)
+
+	// the scope the function was declared in, captured by the
+	// function value created when the declaration is evaluated
+	env *Env
+}
+
+type FunctionDecl struct {
+	fun *Function
+	pos Pos
 }
 
 type FunctionCall struct {
-	fun  *Function
-	args map[Var]Expr
+	callee Expr
+	args   []Expr
+	pos    Pos
 }
 
 type Return struct {
 	expr Expr
+	pos  Pos
+}
+
+// Break leaves the enclosing loop, which evaluates to expr when there is one.
+type Break struct {
+	expr Expr
+	pos  Pos
+}
+
+type Continue struct {
+	pos Pos
 }
 
 func newFloat(f float64) Type {
@@ -110,6 +267,26 @@ func newFloat(f float64) Type {
 	}
 }
 
+func newInt(i int64) Type {
+	return Type{
+		kind: TYPE_INT,
+		as:   As{int: i},
+	}
+}
+
+// newBigInt only keeps the big.Int when it does not fit in an int64,
+// so each int has a single representation. The big.Int is never
+// changed afterwards, as it may be shared between values.
+func newBigInt(b *big.Int) Type {
+	if b.IsInt64() {
+		return newInt(b.Int64())
+	}
+	return Type{
+		kind: TYPE_INT,
+		as:   As{big: b},
+	}
+}
+
 func newStr(s string) Type {
 	return Type{
 		kind: TYPE_STRING,
@@ -117,31 +294,216 @@ func newStr(s string) Type {
 	}
 }
 
-func (env *Env) getFunc(expr Expr) (*Function, error) {
-	var_name, ok := expr.(Var)
-	if !ok {
-		return nil, fmt.Errorf("env get func: invalid function name '%v'", expr)
+func newBool(b bool) Type {
+	return Type{
+		kind: TYPE_BOOL,
+		as:   As{boolean: b},
+	}
+}
+
+func newArray(items []Type) Type {
+	return Type{
+		kind: TYPE_ARRAY,
+		as:   As{array: &Array{items: items}},
+	}
+}
+
+func newMap() Type {
+	return Type{
+		kind: TYPE_MAP,
+		as:   As{dict: &Map{values: make(map[mapKey]Type)}},
+	}
+}
+
+func toMapKey(key Type) (mapKey, error) {
+	// 1 and 1.0 are equal, so they must be the same key
+	if f := key.as.float; key.kind == TYPE_FLOAT && f == math.Trunc(f) && !math.IsInf(f, 0) {
+		n, _ := big.NewFloat(f).Int(nil)
+		key = newBigInt(n)
+	}
+	if key.kind == TYPE_INT {
+		if key.as.big != nil {
+			return mapKey{kind: TYPE_INT, str: key.as.big.String()}, nil
+		}
+		return mapKey{kind: TYPE_INT, int: key.as.int}, nil
+	}
+	switch key.kind {
+	case TYPE_FLOAT, TYPE_STRING, TYPE_BOOL:
+		return mapKey{
+			kind:    key.kind,
+			float:   key.as.float,
+			str:     key.as.str,
+			boolean: key.as.boolean,
+		}, nil
+	}
+	return mapKey{}, fmt.Errorf("invalid map key '%s': only numbers, strings and booleans can be used as keys", key)
+}
+
+func (m *Map) Get(key Type) (Type, bool, error) {
+	k, err := toMapKey(key)
+	if err != nil {
+		return Type{}, false, err
+	}
+	val, ok := m.values[k]
+	return val, ok, nil
+}
+
+func (m *Map) Set(key Type, val Type) error {
+	k, err := toMapKey(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.values[k]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[k] = val
+	return nil
+}
+
+// Delete removes key from the map, reporting whether it was present.
+func (m *Map) Delete(key Type) (bool, error) {
+	k, err := toMapKey(key)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := m.values[k]; !ok {
+		return false, nil
+	}
+	delete(m.values, k)
+	for i, other := range m.keys {
+		if other_k, _ := toMapKey(other); other_k == k {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+func newFunction(fun *Function) Type {
+	return Type{
+		kind: TYPE_FUNCTION,
+		as:   As{fun: fun},
+	}
+}
+
+// displayName is the name of the function,
+// or 'anonymous' for the functions without one.
+func (fun *Function) displayName() string {
+	if fun.name == "" {
+		return "anonymous"
+	}
+	return fun.name
+}
+
+func newRange(start, end int64, inclusive bool) Type {
+	return Type{
+		kind: TYPE_RANGE,
+		as:   As{rng: Range{start: start, end: end, inclusive: inclusive}},
 	}
-	name := string(var_name)
+}
 
-	fun, ok := env.funcs[name]
-	if !ok {
-		if env.parent == nil {
-			return nil, fmt.Errorf("env get func: unknown function name: '%s'", name)
+// get looks name up in env and then in the enclosing scopes.
+func (env *Env) get(name string) (Type, bool) {
+	for ; env != nil; env = env.parent {
+		if val, ok := env.vars[name]; ok {
+			return val, true
 		}
+	}
+	return Type{}, false
+}
 
-		return env.parent.getFunc(expr)
+// assign updates the nearest variable called name,
+// reporting whether there was one.
+func (env *Env) assign(name string, val Type) bool {
+	for ; env != nil; env = env.parent {
+		if _, ok := env.vars[name]; ok {
+			env.vars[name] = val
+			return true
+		}
 	}
-	return fun, nil
+	return false
 }
 
 func (typ Type) String() string {
+	return typ.format(map[any]bool{}, false)
+}
+
+// formatFloat prints the shortest digits that parse back to f,
+// using an exponent only for very small or large numbers.
+// Floats always have a '.' or an exponent, so 1.0 is not printed as the int 1.
+func formatFloat(f float64) string {
+	res := strconv.FormatFloat(f, 'f', -1, 64)
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		res = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if !strings.ContainsAny(res, ".eIN") {
+		res += ".0"
+	}
+	return res
+}
+
+// quoted is used when printing values nested in arrays and maps,
+// so that strings can be told apart from other values.
+func (typ Type) quoted() string {
+	return typ.format(map[any]bool{}, true)
+}
+
+// format prints typ, quoting it when it is a string and quote is set.
+// visiting holds the arrays and maps being printed, so that one
+// containing itself is printed as '[...]' or '{...}' instead of forever.
+func (typ Type) format(visiting map[any]bool, quote bool) string {
 	var res string
 	switch typ.kind {
 	case TYPE_FLOAT:
-		res = fmt.Sprintf("%.2f", typ.as.float)
+		res = formatFloat(typ.as.float)
+	case TYPE_INT:
+		if typ.as.big != nil {
+			res = typ.as.big.String()
+		} else {
+			res = strconv.FormatInt(typ.as.int, 10)
+		}
 	case TYPE_STRING:
 		res = typ.as.str
+		if quote {
+			res = strconv.Quote(typ.as.str)
+		}
+	case TYPE_BOOL:
+		if typ.as.boolean {
+			res = "true"
+		} else {
+			res = "false"
+		}
+	case TYPE_ARRAY:
+		if visiting[typ.as.array] {
+			return "[...]"
+		}
+		visiting[typ.as.array] = true
+		defer delete(visiting, typ.as.array)
+		items := []string{}
+		for _, item := range typ.as.array.items {
+			items = append(items, item.format(visiting, true))
+		}
+		res = "[" + strings.Join(items, ", ") + "]"
+	case TYPE_MAP:
+		if visiting[typ.as.dict] {
+			return "{...}"
+		}
+		visiting[typ.as.dict] = true
+		defer delete(visiting, typ.as.dict)
+		items := []string{}
+		for _, key := range typ.as.dict.keys {
+			val, _, _ := typ.as.dict.Get(key)
+			items = append(items, key.format(visiting, true)+": "+val.format(visiting, true))
+		}
+		res = "{" + strings.Join(items, ", ") + "}"
+	case TYPE_RANGE:
+		op := ".."
+		if typ.as.rng.inclusive {
+			op = "..="
+		}
+		res = fmt.Sprintf("%d%s%d", typ.as.rng.start, op, typ.as.rng.end)
+	case TYPE_FUNCTION:
+		res = fmt.Sprintf("<fn %s/%d>", typ.as.fun.displayName(), len(typ.as.fun.params))
 	default:
 		res = "?????"
 	}
@@ -149,11 +511,18 @@ func (typ Type) String() string {
 }
 
 func (exp Number) String() string {
-	return fmt.Sprintf("%.2f", exp)
+	return exp.value.String()
 }
 
 func (s String) String() string {
-	return string(s)
+	return s.value
+}
+
+func (b Boolean) String() string {
+	if b.value {
+		return "true"
+	}
+	return "false"
 }
 
 func (binop BinOp) String() string {
@@ -183,6 +552,14 @@ func (binop BinOp) String() string {
 	case EQUAL_EQUAL:
 		out.WriteByte('=')
 		out.WriteByte('=')
+	case AND_AND:
+		out.WriteByte('&')
+		out.WriteByte('&')
+	case BANG_EQUAL, MOD, INT_DIV, POW, DOT_DOT, DOT_DOT_EQUAL, PLUS_EQUAL, MINUS_EQUAL, MULT_EQUAL, DIV_EQUAL, MOD_EQUAL:
+		out.WriteString(binop.op.Value)
+	case OR_OR:
+		out.WriteByte('|')
+		out.WriteByte('|')
 	default:
 		out.WriteByte('?')
 	}
@@ -202,6 +579,8 @@ func (unop UnOp) String() string {
 		out.WriteByte('+')
 	case MINUS:
 		out.WriteByte('-')
+	case BANG:
+		out.WriteByte('!')
 	}
 	out.Write([]byte(unop.right.String()))
 	out.Write([]byte{' ', ')'})
@@ -209,7 +588,7 @@ func (unop UnOp) String() string {
 }
 
 func (v Var) String() string {
-	return string(v)
+	return v.name
 }
 
 func (block Block) String() string {
@@ -226,6 +605,26 @@ func (block Block) String() string {
 	return out.String()
 }
 
+func (arr ArrayLit) String() string {
+	items := []string{}
+	for _, item := range arr.items {
+		items = append(items, item.String())
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+func (m MapLit) String() string {
+	items := []string{}
+	for i := range m.keys {
+		items = append(items, m.keys[i].String()+": "+m.values[i].String())
+	}
+	return "{" + strings.Join(items, ", ") + "}"
+}
+
+func (idx Index) String() string {
+	return fmt.Sprintf("%s[%s]", idx.target, idx.index)
+}
+
 func (i If) String() string {
 	return fmt.Sprintf("if (%s) {\n%s\n}\n", i.cond, i.then)
 }
@@ -238,58 +637,116 @@ func (w While) String() string {
 	return fmt.Sprintf("while (%s) {\n%s\n}\n", w.cond, w.then)
 }
 
+func (f For) String() string {
+	return fmt.Sprintf("for %s in (%s) {\n%s\n}\n", f.name, f.iter, f.then)
+}
+
 func (fc FunctionCall) String() string {
-	out := ""
-	name := fc.fun.name
-	out += name + "("
-	for _, param := range fc.fun.params {
-		val, ok := fc.args[param]
-		if ok {
-			out += fmt.Sprintf("%s = %s,", string(param), val.String())
-		} else {
-			out += string(param) + ","
-		}
+	args := []string{}
+	for _, arg := range fc.args {
+		args = append(args, arg.String())
 	}
-	out += ")"
-	return out
+	return fmt.Sprintf("%s(%s)", fc.callee, strings.Join(args, ", "))
 }
 
 func (p Print) String() string {
 	return fmt.Sprintf("PRINT: %s", p.expr.String())
 }
 
+func (ts ToStr) String() string {
+	return fmt.Sprintf("str(%s)", ts.expr)
+}
+
+func (l Let) String() string {
+	return fmt.Sprintf("let %s = %s", l.name, l.value)
+}
+
+func (fd FunctionDecl) String() string {
+	return fmt.Sprintf("fun %s(%s) %s", fd.fun.displayName(), strings.Join(fd.fun.params, ", "), fd.fun.body)
+}
+
 func (r Return) String() string {
 	return fmt.Sprintf("RETURN: %s", r.expr.String())
 }
 
+func (b Break) String() string {
+	if b.expr == nil {
+		return "BREAK"
+	}
+	return fmt.Sprintf("BREAK: %s", b.expr.String())
+}
+
+func (c Continue) String() string {
+	return "CONTINUE"
+}
+
+func (exp Number) Pos() Pos      { return exp.pos }
+func (s String) Pos() Pos        { return s.pos }
+func (b Boolean) Pos() Pos       { return b.pos }
+func (binop BinOp) Pos() Pos     { return binop.op.Pos }
+func (unop UnOp) Pos() Pos       { return unop.op.Pos }
+func (v Var) Pos() Pos           { return v.pos }
+func (l Let) Pos() Pos           { return l.pos }
+func (ts ToStr) Pos() Pos        { return ts.expr.Pos() }
+func (block Block) Pos() Pos     { return block.pos }
+func (arr ArrayLit) Pos() Pos    { return arr.pos }
+func (m MapLit) Pos() Pos        { return m.pos }
+func (idx Index) Pos() Pos       { return idx.pos }
+func (i If) Pos() Pos            { return i.pos }
+func (w While) Pos() Pos         { return w.pos }
+func (f For) Pos() Pos           { return f.pos }
+func (fc FunctionCall) Pos() Pos { return fc.pos }
+func (fd FunctionDecl) Pos() Pos { return fd.pos }
+func (p Print) Pos() Pos         { return p.pos }
+func (r Return) Pos() Pos        { return r.pos }
+func (b Break) Pos() Pos         { return b.pos }
+func (c Continue) Pos() Pos      { return c.pos }
+
 func exprNumber(t Token) (Number, error) {
 	if t.Type != NUMBER {
-		return 0, fmt.Errorf("expr number: invalid number '%s'", t.Value)
+		return Number{}, ParseError{pos: t.Pos, msg: fmt.Sprintf("invalid number '%s'", t.Value)}
 	}
+	// integers with a base prefix, like 0x1F, 0b101 or 0o17
+	if len(t.Value) > 1 && t.Value[0] == '0' && strings.ContainsRune("xXbBoO", rune(t.Value[1])) {
+		n, ok := new(big.Int).SetString(t.Value, 0)
+		if !ok {
+			return Number{}, ParseError{pos: t.Pos, msg: fmt.Sprintf("invalid number '%s'", t.Value)}
+		}
+		return Number{value: newBigInt(n), pos: t.Pos}, nil
+	}
+	// without a fraction or an exponent, the number is an int
+	if !strings.ContainsAny(t.Value, ".eE") {
+		n, ok := new(big.Int).SetString(strings.ReplaceAll(t.Value, "_", ""), 10)
+		if !ok {
+			return Number{}, ParseError{pos: t.Pos, msg: fmt.Sprintf("invalid number '%s'", t.Value)}
+		}
+		return Number{value: newBigInt(n), pos: t.Pos}, nil
+	}
+
 	n, err := strconv.ParseFloat(t.Value, 64)
 	if err != nil {
-		return 0, fmt.Errorf("expr number: invalid number '%s': failed to parse: strconv: %s", t.Value, err)
+		return Number{}, ParseError{pos: t.Pos, msg: fmt.Sprintf("invalid number '%s': failed to parse: strconv: %s", t.Value, err)}
 	}
-	return Number(n), nil
+	return Number{value: newFloat(n), pos: t.Pos}, nil
 }
 
 func exprStr(s Token) (String, error) {
 	if s.Type != STR_LIT {
-		return String(""), fmt.Errorf("expr var: invalid string literal '%s'", s.Value)
+		return String{}, ParseError{pos: s.Pos, msg: fmt.Sprintf("invalid string literal '%s'", s.Value)}
 	}
-	return String(s.Value), nil
+	return String{value: s.Value, pos: s.Pos}, nil
 }
 
 func exprVar(t Token) (Var, error) {
 	if t.Type != ID {
-		return Var(""), fmt.Errorf("expr var: invalid identifier'%s'", t.Value)
+		return Var{}, ParseError{pos: t.Pos, msg: fmt.Sprintf("invalid identifier '%s'", t.Value)}
 	}
 
-	variable := Var(t.Value)
+	variable := Var{name: t.Value, pos: t.Pos}
 	return variable, nil
 }
 
-func exprFunc(name string, params []Var, body Block) Function {
+func exprFunc(name string, params []string, body Expr) Function {
 	return Function{
 		name:   name,
 		params: params,
@@ -299,14 +756,14 @@ func exprFunc(name string, params []Var, body Block) Function {
 
 func newEnv() Env {
 	return Env{
-		vars:   make(map[Var]Type),
-		funcs:  make(map[string]*Function),
+		vars:   make(map[string]Type),
 		parent: nil,
 	}
 }
 
 func NewParser(tokens []Token) Parser {
 	env := newEnv()
+	env.stack = &CallStack{}
 	return Parser{
 		tokens: tokens,
 		cursor: 0,
@@ -321,7 +778,7 @@ func (p *Parser) ResetTokens(tokens []Token) {
 
 func (p *Parser) Peek() Token {
 	if p.cursor >= len(p.tokens) {
-		return NewEOF()
+		return p.eof()
 	}
 	tok := p.tokens[p.cursor]
 	return tok
@@ -329,7 +786,7 @@ func (p *Parser) Peek() Token {
 
 func (p *Parser) Next() Token {
 	if p.cursor >= len(p.tokens) {
-		return NewEOF()
+		return p.eof()
 	}
 
 	tok := p.tokens[p.cursor]
@@ -337,27 +794,92 @@ func (p *Parser) Next() Token {
 	return tok
 }
 
+// eof returns an EOF token positioned at the end of the input.
+func (p *Parser) eof() Token {
+	tok := NewEOF()
+	if len(p.tokens) > 0 {
+		tok.Pos = p.tokens[len(p.tokens)-1].Pos
+	}
+	return tok
+}
+
+// Expect consumes the next token if it has the type of tok.
+// On a mismatch the token is left in place, so the recovery
+// from the error can take it into account.
 func (p *Parser) Expect(tok Token) error {
-	next := p.Next()
+	next := p.Peek()
 	if next.Type != tok.Type {
-		return fmt.Errorf("expected %s, got %s", tok.Type, next.Type)
+		return ParseError{pos: next.Pos, msg: fmt.Sprintf("expected %s, got %s", tok.Type, next.Type)}
 	}
+	p.Next()
 	return nil
 }
 
 func (p *Parser) Assert(tok Token, typ TokenType) error {
 	if tok.Type != typ {
-		return fmt.Errorf("Parser Assert: expected '%s', got '%s'", typ, tok.Type)
+		return ParseError{pos: tok.Pos, msg: fmt.Sprintf("expected '%s', got '%s'", typ, tok.Type)}
 	}
 	return nil
 }
 
-func (p *Parser) Parse() Expr {
-	return p.Block()
+// Parse parses the whole input, returning every syntax error found
+// joined together.
+func (p *Parser) Parse() (Expr, error) {
+	p.errors = nil
+	block := Block{pos: p.Peek().Pos}
+	p.Statements(&block)
+
+	// a stray '}' ends the top level block early,
+	// so it is reported and the parsing resumes after it
+	for p.Peek().Type == RIGHT_CURLY {
+		tok := p.Next()
+		p.errors = append(p.errors, ParseError{pos: tok.Pos, msg: "unexpected '}'"})
+		p.Statements(&block)
+	}
+
+	if len(p.errors) > 0 {
+		return nil, errors.Join(p.errors...)
+	}
+	return block, nil
+}
+
+// synchronize skips the tokens of a statement with a syntax error,
+// stopping after the next ';' or before the '}' that closes the current block.
+// Braces opened while skipping are skipped as a whole.
+func (p *Parser) synchronize() {
+	depth := 0
+	for {
+		switch p.Peek().Type {
+		case EOF:
+			return
+		case SEMICOLON:
+			p.Next()
+			if depth == 0 {
+				return
+			}
+		case LEFT_CURLY:
+			p.Next()
+			depth++
+		case RIGHT_CURLY:
+			if depth == 0 {
+				return
+			}
+			p.Next()
+			depth--
+			if depth == 0 {
+				return
+			}
+		default:
+			p.Next()
+		}
+	}
 }
 
-func (p *Parser) IfElse() (res Expr, err error) {
-	cond := p.Expression(0)
+func (p *Parser) IfElse(pos Pos) (res Expr, err error) {
+	cond, err := p.Operand(0, "if condition")
+	if err != nil {
+		return
+	}
 	err = p.Expect(NewLeftCurly())
 	if err != nil {
 		return
@@ -371,6 +893,7 @@ func (p *Parser) IfElse() (res Expr, err error) {
 	res = If{
 		cond: cond,
 		then: then,
+		pos:  pos,
 	}
 
 	if p.Peek().Type == ELSE {
@@ -396,18 +919,19 @@ func (p *Parser) IfElse() (res Expr, err error) {
 	return
 }
 
-func (p *Parser) While() (w Expr, err error) {
+func (p *Parser) While(pos Pos) (w Expr, err error) {
 	w = While{}
 
-	cond := p.Expression(0)
+	cond, err := p.Operand(0, "while condition")
+	if err != nil {
+		return
+	}
 	err = p.Expect(NewLeftCurly())
 	if err != nil {
 		return
 	}
 
-	env := newEnv()
-	env.vars = p.env.vars
-	then := p.Block(env)
+	then := p.LoopBody()
 	err = p.Expect(NewRightCurly())
 	if err != nil {
 		return
@@ -416,50 +940,201 @@ func (p *Parser) While() (w Expr, err error) {
 	w = While{
 		cond: cond,
 		then: then,
+		pos:  pos,
 	}
 	return
 }
 
-func (p *Parser) Block(envs ...Env) Block {
-	var new_env Env
-	if len(envs) > 0 {
-		new_env = envs[0]
+// For parses both 'for range iter { }', which binds each item to 'it',
+// and 'for name in iter { }'.
+func (p *Parser) For(pos Pos) (f Expr, err error) {
+	f = For{}
+
+	name := "it"
+	if p.Peek().Type == RANGE {
+		p.Next()
 	} else {
-		new_env = newEnv()
+		name_tok := p.Peek()
+		err = p.Expect(Token{Type: ID})
+		if err != nil {
+			return f, fmt.Errorf("for: expected 'range' or a loop variable: %w", err)
+		}
+		name = name_tok.Value
+		err = p.Expect(Token{Type: IN})
+		if err != nil {
+			return f, fmt.Errorf("for: expected 'in' after the loop variable: %w", err)
+		}
 	}
 
-	new_env.parent = p.env
-	block := Block{env: &new_env}
-	p.env = block.env
+	iter, err := p.Operand(0, "value to iterate")
+	if err != nil {
+		return
+	}
+	err = p.Expect(NewLeftCurly())
+	if err != nil {
+		return
+	}
+
+	then := p.LoopBody()
+	err = p.Expect(NewRightCurly())
+	if err != nil {
+		return
+	}
 
+	f = For{
+		name: name,
+		iter: iter,
+		then: then,
+		pos:  pos,
+	}
+	return
+}
+
+// LoopBody parses the block of a loop, where 'break' and 'continue' are valid.
+func (p *Parser) LoopBody() Block {
+	p.loop_depth++
+	defer func() { p.loop_depth-- }()
+	return p.Block()
+}
+
+func (p *Parser) Block() Block {
+	block := Block{pos: p.Peek().Pos}
+	p.Statements(&block)
+	return block
+}
+
+// Statements parses expressions into block until the '}' that closes it.
+// A syntax error is recorded and the parsing resumes on the next statement.
+// Only statements consume the ';' ending them, so that an expression
+// nested in a statement, like the value of a 'let', cannot end it early.
+func (p *Parser) Statements(block *Block) {
 	for p.Peek().Type != RIGHT_CURLY {
-		if p.cursor >= len(p.tokens) {
+		if p.Peek().Type == EOF {
 			break
 		}
-		expr := p.Expression(0)
+		expr, err := p.Expression(0)
+		if err != nil {
+			p.errors = append(p.errors, err)
+			p.synchronize()
+			continue
+		}
 		if expr != nil {
 			block.exprs = append(block.exprs, expr)
 		}
+		if p.Peek().Type == SEMICOLON {
+			p.Next()
+		}
 	}
-
-	p.env = block.env.parent
-	return block
 }
 
-func (p *Parser) FunctionDeclaration() error {
+// FunctionDeclaration parses 'fun name(params) { body }',
+// or the anonymous 'fun(params) { body }'.
+func (p *Parser) FunctionDeclaration(pos Pos) (Expr, error) {
+	if p.Peek().Type == LEFT_PAREN {
+		return p.Function(pos, "", LEFT_CURLY)
+	}
 	func_name_tok := p.Next()
 	err := p.Assert(func_name_tok, ID)
 	if err != nil {
-		return fmt.Errorf("function declaration: invalid function name: %s", err)
+		return nil, fmt.Errorf("function declaration: invalid function name: %w", err)
 	}
-	name := func_name_tok.Value
+	return p.Function(pos, func_name_tok.Value, LEFT_CURLY)
+}
 
-	err = p.Expect(NewLeftParen())
+// Function parses the parameters and the body of a function declaration,
+// either 'fun name(params) { body }' or 'let name(params) = body',
+// body_start tells which one of them.
+func (p *Parser) Function(pos Pos, name string, body_start TokenType) (Expr, error) {
+	err := p.Expect(NewLeftParen())
+	if err != nil {
+		return nil, fmt.Errorf("function declaration: expected '(' after function name: %w", err)
+	}
+	params, err := p.Params(NewRightParen())
 	if err != nil {
-		return fmt.Errorf("function declaration: expected '(' after function name")
+		return nil, err
 	}
 
-	params := []Var{}
+	// the loops around a function do not enclose its body
+	loop_depth := p.loop_depth
+	p.loop_depth = 0
+	defer func() { p.loop_depth = loop_depth }()
+
+	var body Expr
+	if body_start == EQUAL {
+		err = p.Expect(Token{Type: EQUAL})
+		if err != nil {
+			return nil, fmt.Errorf("function declaration: expected '=' after function's parameters: %w", err)
+		}
+		body, err = p.Operand(0, "function body")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = p.Expect(NewLeftCurly())
+		if err != nil {
+			return nil, fmt.Errorf("function declaration: expected '{' after function's parameters: %w", err)
+		}
+		body = p.Block()
+		err = p.Expect(NewRightCurly())
+		if err != nil {
+			return nil, fmt.Errorf("function declaration: expected '}' after function's body: %w", err)
+		}
+	}
+	fun := exprFunc(name, params, body)
+	return FunctionDecl{fun: &fun, pos: pos}, nil
+}
+
+// Break parses 'break' with its optional value.
+func (p *Parser) Break(pos Pos) (Expr, error) {
+	if p.loop_depth == 0 {
+		return nil, ParseError{pos: pos, msg: "'break' outside of a loop"}
+	}
+	switch p.Peek().Type {
+	case SEMICOLON, RIGHT_CURLY, EOF:
+		return Break{pos: pos}, nil
+	}
+	expr, err := p.Operand(0, "value to break with")
+	if err != nil {
+		return nil, err
+	}
+	return Break{expr: expr, pos: pos}, nil
+}
+
+// Interpolation parses the parts of an interpolated string,
+// joining them into a concatenation: "a{x}b" is "a" + str(x) + "b".
+func (p *Parser) Interpolation(head Token) (Expr, error) {
+	var str Expr = String{value: head.Value, pos: head.Pos}
+	for {
+		expr, err := p.Operand(0, "expression to interpolate")
+		if err != nil {
+			return nil, err
+		}
+		str = BinOp{
+			left:  str,
+			right: ToStr{expr: expr},
+			op:    Token{Type: PLUS, Value: "+", Pos: expr.Pos()},
+		}
+
+		part := p.Next()
+		if part.Type != STR_MIDDLE && part.Type != STR_TAIL {
+			return nil, ParseError{pos: part.Pos, msg: fmt.Sprintf("expected '}' after interpolated expression, got '%s'", part.Value)}
+		}
+		if part.Value != "" {
+			str = BinOp{
+				left:  str,
+				right: String{value: part.Value, pos: part.Pos},
+				op:    Token{Type: PLUS, Value: "+", Pos: part.Pos},
+			}
+		}
+		if part.Type == STR_TAIL {
+			return str, nil
+		}
+	}
+}
+
+// Params parses the parameters of a function up to the closing token.
+func (p *Parser) Params(closing Token) ([]string, error) {
+	params := []string{}
 params_loop:
 	for {
 		typ := p.Peek().Type
@@ -467,9 +1142,9 @@ params_loop:
 		case ID:
 			param, err := exprVar(p.Next())
 			if err != nil {
-				return fmt.Errorf("function declaration: invalid function parameter: %s", err)
+				return nil, fmt.Errorf("function declaration: invalid function parameter: %w", err)
 			}
-			params = append(params, param)
+			params = append(params, param.name)
 		case COMMA:
 			p.Next()
 		default:
@@ -477,194 +1152,328 @@ params_loop:
 		}
 	}
 
-	err = p.Expect(NewRightParen())
+	err := p.Expect(closing)
 	if err != nil {
-		return fmt.Errorf("function declaration: expected ')' after function's params")
+		return nil, fmt.Errorf("function declaration: expected '%s' after function's params: %w", closing.Value, err)
 	}
+	return params, nil
+}
 
-	err = p.Expect(NewLeftCurly())
+// Lambda parses '|params| body', the opening '|' was already consumed.
+// A '||' in its place is a lambda without parameters.
+func (p *Parser) Lambda(pos Pos, opening TokenType) (Expr, error) {
+	params := []string{}
+	if opening == PIPE {
+		var err error
+		params, err = p.Params(NewPipe())
+		if err != nil {
+			return nil, err
+		}
+	}
+	loop_depth := p.loop_depth
+	p.loop_depth = 0
+	defer func() { p.loop_depth = loop_depth }()
+
+	body, err := p.Operand(0, "lambda body")
 	if err != nil {
-		return fmt.Errorf("function declaration: expected '{' after function's parameters ")
+		return nil, err
 	}
+	fun := exprFunc("", params, body)
+	return FunctionDecl{fun: &fun, pos: pos}, nil
+}
 
-	fun := &Function{name: name, params: params}
-	p.env.funcs[name] = fun
+func (p *Parser) Let(pos Pos) (Expr, error) {
+	name_tok := p.Peek()
+	err := p.Expect(Token{Type: ID})
+	if err != nil {
+		return nil, fmt.Errorf("let: expected a variable name: %w", err)
+	}
+	if p.Peek().Type == LEFT_PAREN {
+		return p.Function(pos, name_tok.Value, EQUAL)
+	}
+	err = p.Expect(Token{Type: EQUAL})
+	if err != nil {
+		return nil, fmt.Errorf("let: expected '=' after the variable name: %w", err)
+	}
+	value, err := p.Operand(0, "value of the variable")
+	if err != nil {
+		return nil, err
+	}
+	return Let{name: name_tok.Value, value: value, pos: pos}, nil
+}
 
-	env := newEnv()
-	// for _, param := range params {
-	// 	env.vars[param] = newFloat(0.0)
-	// }
+func (p *Parser) ArrayLit(pos Pos) (Expr, error) {
+	items := []Expr{}
+	for peek := p.Peek().Type; peek != RIGHT_BRACKET && peek != EOF; peek = p.Peek().Type {
+		item, err := p.Operand(0, "array item")
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.Peek().Type == COMMA {
+			p.Next()
+		} else {
+			break
+		}
+	}
 
-	body := p.Block(env)
-	*fun = exprFunc(name, params, body)
+	err := p.Expect(NewRightBracket())
+	if err != nil {
+		return nil, fmt.Errorf("array literal: expected ']' after items: %w", err)
+	}
+	return ArrayLit{items: items, pos: pos}, nil
+}
 
-	err = p.Expect(NewRightCurly())
+// isMapLit decides if the '{' that was just consumed starts a map literal
+// rather than a block, by looking for a ':' before the end of the first
+// expression inside it. An empty '{}' is an empty map.
+func (p *Parser) isMapLit() bool {
+	depth := 0
+	for i := p.cursor; i < len(p.tokens); i++ {
+		switch p.tokens[i].Type {
+		case LEFT_PAREN, LEFT_BRACKET, LEFT_CURLY:
+			depth++
+		case RIGHT_PAREN, RIGHT_BRACKET:
+			depth--
+		case RIGHT_CURLY:
+			if depth == 0 {
+				return i == p.cursor
+			}
+			depth--
+		case SEMICOLON:
+			if depth == 0 {
+				return false
+			}
+		case COLON:
+			if depth == 0 {
+				return true
+			}
+		case EOF:
+			return false
+		}
+	}
+	return false
+}
+
+func (p *Parser) MapLit(pos Pos) (Expr, error) {
+	m := MapLit{pos: pos}
+	for peek := p.Peek().Type; peek != RIGHT_CURLY && peek != EOF; peek = p.Peek().Type {
+		key, err := p.Operand(0, "map key")
+		if err != nil {
+			return nil, err
+		}
+		err = p.Expect(NewColon())
+		if err != nil {
+			return nil, fmt.Errorf("map literal: expected ':' after key: %w", err)
+		}
+		val, err := p.Operand(0, "map value")
+		if err != nil {
+			return nil, err
+		}
+		m.keys = append(m.keys, key)
+		m.values = append(m.values, val)
+		if p.Peek().Type == COMMA {
+			p.Next()
+		} else {
+			break
+		}
+	}
+
+	err := p.Expect(NewRightCurly())
 	if err != nil {
-		return fmt.Errorf("function declaration: expected '}' after function's body")
+		return nil, fmt.Errorf("map literal: expected '}' after items: %w", err)
 	}
+	return m, nil
+}
 
-	if p.Peek().Type == SEMICOLON {
-		p.Next()
+// FunctionCall parses the arguments of a call, the callee is only
+// known to be a function when the call is evaluated.
+func (p *Parser) FunctionCall(callee Expr) (Expr, error) {
+	args := []Expr{}
+	for peek := p.Peek().Type; peek != RIGHT_PAREN && peek != EOF; peek = p.Peek().Type {
+		arg, err := p.Operand(0, "argument")
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.Peek().Type == COMMA {
+			p.Next()
+		} else {
+			break
+		}
 	}
 
-	return nil
+	err := p.Expect(NewRightParen())
+	if err != nil {
+		return nil, fmt.Errorf("expected ')' in function call: %w", err)
+	}
+
+	return FunctionCall{
+		callee: callee,
+		args:   args,
+		pos:    callee.Pos(),
+	}, nil
 }
 
-func (p *Parser) Expression(prev_bp int) Expr {
+// Operand parses an expression that must be present.
+// what names the missing expression in the error message.
+func (p *Parser) Operand(prev_bp int, what string) (Expr, error) {
+	tok := p.Peek()
+	switch tok.Type {
+	case SEMICOLON, EOF:
+		// not consumed, so the statement ends here
+		return nil, ParseError{pos: tok.Pos, msg: fmt.Sprintf("expected %s, got '%s'", what, tok.Value)}
+	case STR_MIDDLE, STR_TAIL:
+		// the '}' closing a string interpolation
+		return nil, ParseError{pos: tok.Pos, msg: fmt.Sprintf("expected %s, got '}'", what)}
+	}
+	expr, err := p.Expression(prev_bp)
+	if err != nil {
+		return nil, err
+	}
+	if expr == nil {
+		return nil, ParseError{pos: tok.Pos, msg: fmt.Sprintf("expected %s, got '%s'", what, tok.Value)}
+	}
+	return expr, nil
+}
+
+func (p *Parser) Expression(prev_bp int) (Expr, error) {
 	var left Expr
+	var err error
 
 	left_tok := p.Next()
 	switch left_tok.Type {
 	case NUMBER:
-		var err error
 		left, err = exprNumber(left_tok)
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
-		}
 	case STR_LIT:
-		var err error
 		left, err = exprStr(left_tok)
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
-		}
+	case STR_HEAD:
+		left, err = p.Interpolation(left_tok)
+	case TRUE:
+		left = Boolean{value: true, pos: left_tok.Pos}
+	case FALSE:
+		left = Boolean{value: false, pos: left_tok.Pos}
 	case LEFT_PAREN:
-		left = p.Expression(0)
-		err := p.Expect(NewRightParen())
+		left, err = p.Operand(0, "expression")
 		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
+			return nil, err
 		}
+		err = p.Expect(NewRightParen())
+	case LEFT_BRACKET:
+		left, err = p.ArrayLit(left_tok.Pos)
 	case LEFT_CURLY:
-		left = p.Block()
-		err := p.Expect(NewRightCurly())
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
+		if p.isMapLit() {
+			left, err = p.MapLit(left_tok.Pos)
+			break
 		}
-	case PLUS, MINUS:
+		block := p.Block()
+		block.pos = left_tok.Pos
+		left = block
+		err = p.Expect(NewRightCurly())
+	case PLUS, MINUS, BANG:
 		_, rbp := prefixBindingPower(left_tok.Type)
+		var right Expr
+		right, err = p.Operand(rbp, fmt.Sprintf("operand for '%s'", left_tok.Value))
 		left = UnOp{
 			op:    left_tok,
-			right: p.Expression(rbp),
+			right: right,
 		}
 	case ID:
-		var err error
 		left, err = exprVar(left_tok)
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
-		}
 	case FUNCTION:
-		err := p.FunctionDeclaration()
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
+		named := p.Peek().Type != LEFT_PAREN
+		left, err = p.FunctionDeclaration(left_tok.Pos)
+		// a named declaration is a statement of its own, so a '(', '[' or
+		// operator on the next line starts a new statement instead of using it
+		if named && err == nil {
+			return left, nil
 		}
-		return nil
-
+	case LET:
+		left, err = p.Let(left_tok.Pos)
+	case PIPE, OR_OR:
+		left, err = p.Lambda(left_tok.Pos, left_tok.Type)
 	case IF:
-		var err error
-		left, err = p.IfElse()
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
-		}
+		left, err = p.IfElse(left_tok.Pos)
 	case WHILE:
-		var err error
-		left, err = p.While()
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
-		}
+		left, err = p.While(left_tok.Pos)
+	case FOR:
+		left, err = p.For(left_tok.Pos)
 	case PRINT:
+		var expr Expr
+		expr, err = p.Operand(0, "expression to print")
 		left = Print{
-			expr: p.Expression(0),
+			expr: expr,
+			pos:  left_tok.Pos,
 		}
 	case RETURN:
+		var expr Expr
+		expr, err = p.Operand(0, "expression to return")
 		left = Return{
-			expr: p.Expression(0),
+			expr: expr,
+			pos:  left_tok.Pos,
 		}
-	case SEMICOLON:
-		return nil
+	case BREAK:
+		left, err = p.Break(left_tok.Pos)
+	case CONTINUE:
+		if p.loop_depth == 0 {
+			return nil, ParseError{pos: left_tok.Pos, msg: "'continue' outside of a loop"}
+		}
+		left = Continue{pos: left_tok.Pos}
+	case SEMICOLON, EOF:
+		return nil, nil
 	default:
-		return nil
+		return nil, ParseError{pos: left_tok.Pos, msg: fmt.Sprintf("unexpected '%s'", left_tok.Value)}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	for {
 		op := p.Peek()
 		if op.Type == SEMICOLON {
-			p.Next()
-			return left
+			return left, nil
 		}
 
 		lbp, _ := postfixBindingPower(op.Type)
 		if lbp != -1 {
 			if lbp <= prev_bp {
-				return left
-			}
-
-			function, err := p.env.getFunc(left)
-			if err != nil {
-				fmt.Printf("ERROR: invalid function call: %s\n", err)
-				os.Exit(1)
-			}
-
-			// NOTE: the only possible postfix operator for now is a(b)
-			err = p.Assert(p.Next(), LEFT_PAREN)
-			if err != nil {
-				fmt.Printf("ERROR: only supported postfix operator is Function Call. Invalid operator: '%s'\n", op.Value)
-				os.Exit(1)
+				return left, nil
 			}
 
-			args := []Expr{}
-
-			for peek := p.Peek().Type; peek != RIGHT_PAREN && peek != EOF; peek = p.Peek().Type {
-				arg := p.Expression(0)
-				args = append(args, arg)
-				if p.Peek().Type == COMMA {
-					p.Next()
-				} else {
-					break
+			p.Next()
+			switch op.Type {
+			case LEFT_PAREN:
+				left, err = p.FunctionCall(left)
+				if err != nil {
+					return nil, fmt.Errorf("invalid function call: %w", err)
+				}
+			case LEFT_BRACKET:
+				index, err := p.Operand(0, "index")
+				if err != nil {
+					return nil, err
+				}
+				err = p.Expect(NewRightBracket())
+				if err != nil {
+					return nil, fmt.Errorf("expected ']' after index: %w", err)
+				}
+				left = Index{
+					target: left,
+					index:  index,
+					pos:    op.Pos,
 				}
-			}
-
-			if len(args) > len(function.params) {
-				fmt.Printf("ERROR: expected at most %d arguments, but got %d\n", len(function.params), len(args))
-				os.Exit(1)
-			}
-
-			args_map := make(map[Var]Expr)
-			for i := range len(args) {
-				arg := args[i]
-				param := function.params[i]
-				args_map[param] = arg
-			}
-
-			err = p.Expect(NewRightParen())
-			if err != nil {
-				fmt.Printf("ERROR: expected ')' in function call: %s\n", err)
-				os.Exit(1)
-			}
-
-			left = FunctionCall{
-				fun:  function,
-				args: args_map,
 			}
 			continue
 		}
 
 		lbp, rbp := infixBindingPower(op.Type)
 		if lbp <= prev_bp {
-			return left
+			return left, nil
 		}
 		p.Next()
 
-		right := p.Expression(rbp)
-
-		if right == nil {
-			return left
+		right, err := p.Operand(rbp, fmt.Sprintf("right operand for '%s'", op.Value))
+		if err != nil {
+			return nil, err
 		}
 
 		left = BinOp{
@@ -677,22 +1486,33 @@ func (p *Parser) Expression(prev_bp int) Expr {
 
 func postfixBindingPower(toktype TokenType) (int, int) {
 	switch toktype {
-	case LEFT_PAREN:
-		return 9, -1
+	case LEFT_PAREN, LEFT_BRACKET:
+		return 17, -1
 	}
 	return -1, -1
 }
 
 func infixBindingPower(toktype TokenType) (int, int) {
 	switch toktype {
-	case EQUAL:
-		return 1, 2
-	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, EQUAL_EQUAL:
+	// assignments are right associative, 'a = b = c' is 'a = (b = c)'
+	case EQUAL, PLUS_EQUAL, MINUS_EQUAL, MULT_EQUAL, DIV_EQUAL, MOD_EQUAL:
+		return 2, 1
+	case OR_OR:
 		return 3, 4
-	case PLUS, MINUS:
+	case AND_AND:
 		return 5, 6
-	case MULT, DIV:
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, EQUAL_EQUAL, BANG_EQUAL:
 		return 7, 8
+	case DOT_DOT, DOT_DOT_EQUAL:
+		return 9, 10
+	case PLUS, MINUS:
+		return 11, 12
+	case MULT, DIV, MOD, INT_DIV:
+		return 13, 14
+	// binds tighter than a prefix minus, '-2 ** 2' is '-(2 ** 2)',
+	// and is right associative, '2 ** 3 ** 2' is '2 ** (3 ** 2)'
+	case POW:
+		return 16, 15
 	case EOF:
 		return 0, 0
 	}
@@ -710,8 +1530,8 @@ func infixBindingPower(toktype TokenType) (int, int) {
 //	_, rbp := prefixBindingPower(toktype)
 func prefixBindingPower(toktype TokenType) (int, int) {
 	switch toktype {
-	case PLUS, MINUS:
-		return -1, 10
+	case PLUS, MINUS, BANG:
+		return -1, 15
 	case EOF:
 		return -1, 0
 	}
@@ -1,32 +1,58 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
 
+// Pos is a location in the source code.
+// Line and Col start at 1, Offset is the byte offset from the start of the input.
+type Pos struct {
+	Line   int
+	Col    int
+	Offset int
+}
+
 type Token struct {
 	Type  TokenType
 	Value string
+	Pos   Pos
 }
 
 type Tokenizer struct {
 	input  string
 	cursor int
+
+	// position of the last scanned offset,
+	// so positions are computed incrementally
+	pos Pos
+
+	// tokens already scanned, returned before scanning any further,
+	// an interpolated string is scanned into many tokens at once
+	pending []Token
 }
 
 var KEYWORDS = map[string]TokenType{
-	"fun":    FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"for":    FOR,
-	"while":  WHILE,
-	"print":  PRINT,
-	"return": RETURN,
+	"fun":      FUNCTION,
+	"let":      LET,
+	"if":       IF,
+	"else":     ELSE,
+	"for":      FOR,
+	"while":    WHILE,
+	"print":    PRINT,
+	"return":   RETURN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"true":     TRUE,
+	"false":    FALSE,
+	"range":    RANGE,
+	"in":       IN,
 }
 
 const (
@@ -40,14 +66,32 @@ const (
 	GREATER
 	LESS
 	EQUAL_EQUAL
+	BANG_EQUAL
 	GREATER_EQUAL
 	LESS_EQUAL
+	BANG
+	AND_AND
+	OR_OR
+	PIPE
+	DOT_DOT
+	DOT_DOT_EQUAL
 	NUMBER
 	PLUS
 	MINUS
 	MULT
 	DIV
+	MOD
+	INT_DIV
+	POW
+	PLUS_EQUAL
+	MINUS_EQUAL
+	MULT_EQUAL
+	DIV_EQUAL
+	MOD_EQUAL
 	STR_LIT
+	STR_HEAD
+	STR_MIDDLE
+	STR_TAIL
 	ID
 	FUNCTION
 	LET
@@ -57,8 +101,15 @@ const (
 	WHILE
 	PRINT
 	COMMA
+	COLON
 	SEMICOLON
 	RETURN
+	BREAK
+	CONTINUE
+	TRUE
+	FALSE
+	RANGE
+	IN
 	EOF
 )
 
@@ -84,10 +135,24 @@ func (tt TokenType) String() string {
 		return "LESS"
 	case EQUAL_EQUAL:
 		return "EQUAL_EQUAL"
+	case BANG_EQUAL:
+		return "BANG_EQUAL"
 	case GREATER_EQUAL:
 		return "GREATER_EQUAL"
 	case LESS_EQUAL:
 		return "LESS_EQUAL"
+	case BANG:
+		return "BANG"
+	case AND_AND:
+		return "AND_AND"
+	case OR_OR:
+		return "OR_OR"
+	case PIPE:
+		return "PIPE"
+	case DOT_DOT:
+		return "DOT_DOT"
+	case DOT_DOT_EQUAL:
+		return "DOT_DOT_EQUAL"
 	case NUMBER:
 		return "NUMBER"
 	case PLUS:
@@ -98,8 +163,30 @@ func (tt TokenType) String() string {
 		return "MULT"
 	case DIV:
 		return "DIV"
+	case MOD:
+		return "MOD"
+	case INT_DIV:
+		return "INT_DIV"
+	case POW:
+		return "POW"
+	case PLUS_EQUAL:
+		return "PLUS_EQUAL"
+	case MINUS_EQUAL:
+		return "MINUS_EQUAL"
+	case MULT_EQUAL:
+		return "MULT_EQUAL"
+	case DIV_EQUAL:
+		return "DIV_EQUAL"
+	case MOD_EQUAL:
+		return "MOD_EQUAL"
 	case STR_LIT:
 		return "STR_LIT"
+	case STR_HEAD:
+		return "STR_HEAD"
+	case STR_MIDDLE:
+		return "STR_MIDDLE"
+	case STR_TAIL:
+		return "STR_TAIL"
 	case ID:
 		return "ID"
 	case FUNCTION:
@@ -120,16 +207,34 @@ func (tt TokenType) String() string {
 		return "SEMICOLON"
 	case COMMA:
 		return "COMMA"
+	case COLON:
+		return "COLON"
 	case RETURN:
 		return "RETURN"
+	case BREAK:
+		return "BREAK"
+	case CONTINUE:
+		return "CONTINUE"
+	case TRUE:
+		return "TRUE"
+	case FALSE:
+		return "FALSE"
+	case RANGE:
+		return "RANGE"
+	case IN:
+		return "IN"
 	case EOF:
 		return "EOF"
 	}
 	return "UNKNOWN TOKEN TYPE"
 }
 
+func (pos Pos) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Col)
+}
+
 func (tok Token) String() string {
-	return fmt.Sprintf("%12s: %s", tok.Type, tok.Value)
+	return fmt.Sprintf("%12s: %s (%s)", tok.Type, tok.Value, tok.Pos)
 }
 
 func NewLeftParen() Token {
@@ -208,6 +313,55 @@ func NewEqualEqual() Token {
 	}
 }
 
+func NewBangEqual() Token {
+	return Token{
+		Type:  BANG_EQUAL,
+		Value: "!=",
+	}
+}
+
+func NewBang() Token {
+	return Token{
+		Type:  BANG,
+		Value: "!",
+	}
+}
+
+func NewAndAnd() Token {
+	return Token{
+		Type:  AND_AND,
+		Value: "&&",
+	}
+}
+
+func NewOrOr() Token {
+	return Token{
+		Type:  OR_OR,
+		Value: "||",
+	}
+}
+
+func NewPipe() Token {
+	return Token{
+		Type:  PIPE,
+		Value: "|",
+	}
+}
+
+func NewDotDot() Token {
+	return Token{
+		Type:  DOT_DOT,
+		Value: "..",
+	}
+}
+
+func NewDotDotEqual() Token {
+	return Token{
+		Type:  DOT_DOT_EQUAL,
+		Value: "..=",
+	}
+}
+
 func NewEqual() Token {
 	return Token{
 		Type:  EQUAL,
@@ -243,6 +397,62 @@ func NewDiv() Token {
 	}
 }
 
+func NewMod() Token {
+	return Token{
+		Type:  MOD,
+		Value: "%",
+	}
+}
+
+func NewIntDiv() Token {
+	return Token{
+		Type:  INT_DIV,
+		Value: "~/",
+	}
+}
+
+func NewPow() Token {
+	return Token{
+		Type:  POW,
+		Value: "**",
+	}
+}
+
+func NewPlusEqual() Token {
+	return Token{
+		Type:  PLUS_EQUAL,
+		Value: "+=",
+	}
+}
+
+func NewMinusEqual() Token {
+	return Token{
+		Type:  MINUS_EQUAL,
+		Value: "-=",
+	}
+}
+
+func NewMultEqual() Token {
+	return Token{
+		Type:  MULT_EQUAL,
+		Value: "*=",
+	}
+}
+
+func NewDivEqual() Token {
+	return Token{
+		Type:  DIV_EQUAL,
+		Value: "/=",
+	}
+}
+
+func NewModEqual() Token {
+	return Token{
+		Type:  MOD_EQUAL,
+		Value: "%=",
+	}
+}
+
 func NewNumber(n string) Token {
 	return Token{
 		Type:  NUMBER,
@@ -250,6 +460,27 @@ func NewNumber(n string) Token {
 	}
 }
 
+func NewStrHead(s string) Token {
+	return Token{
+		Type:  STR_HEAD,
+		Value: s,
+	}
+}
+
+func NewStrMiddle(s string) Token {
+	return Token{
+		Type:  STR_MIDDLE,
+		Value: s,
+	}
+}
+
+func NewStrTail(s string) Token {
+	return Token{
+		Type:  STR_TAIL,
+		Value: s,
+	}
+}
+
 func NewStrLit(s string) Token {
 	return Token{
 		Type:  STR_LIT,
@@ -271,6 +502,13 @@ func NewComma() Token {
 	}
 }
 
+func NewColon() Token {
+	return Token{
+		Type:  COLON,
+		Value: ":",
+	}
+}
+
 func NewSemiColon() Token {
 	return Token{
 		Type:  SEMICOLON,
@@ -289,20 +527,64 @@ func NewTokenizer(input string) Tokenizer {
 	return Tokenizer{
 		input:  input,
 		cursor: 0,
+		pos:    Pos{Line: 1, Col: 1, Offset: 0},
+	}
+}
+
+// Position returns the position of the byte offset in the input,
+// with the column counted in runes.
+// Offsets must be requested in increasing order.
+func (t *Tokenizer) Position(offset int) Pos {
+	for t.pos.Offset < offset && t.pos.Offset < len(t.input) {
+		t.pos.Offset++
+		if t.input[t.pos.Offset-1] == '\n' {
+			t.pos.Line++
+			t.pos.Col = 1
+		} else if t.pos.Offset >= len(t.input) || utf8.RuneStart(t.input[t.pos.Offset]) {
+			t.pos.Col++
+		}
 	}
+	return t.pos
 }
 
 func (t *Tokenizer) Next() (Token, error) {
+	if len(t.pending) > 0 {
+		tok := t.pending[0]
+		t.pending = t.pending[1:]
+		return tok, nil
+	}
+
+	err := t.skipWhitespaceAndComments()
+	if err != nil {
+		return Token{}, err
+	}
+
+	start := t.cursor
+	pos := t.Position(start)
+	tok, err := t.next()
+	if err != nil {
+		// always move forward, so scanning can go on after the error
+		if t.cursor == start {
+			_, size := utf8.DecodeRuneInString(t.input[start:])
+			t.cursor += size
+		}
+		// errors inside an interpolation are already positioned
+		if _, ok := err.(ParseError); ok {
+			return Token{}, err
+		}
+		return Token{}, ParseError{pos: pos, msg: err.Error()}
+	}
+	tok.Pos = pos
+	return tok, nil
+}
+
+func (t *Tokenizer) next() (Token, error) {
 	if t.isEnd() {
 		return NewEOF(), nil
 	}
-	char := t.input[t.cursor]
-	for unicode.IsSpace(rune(char)) {
-		t.cursor++
-		if t.isEnd() {
-			return NewEOF(), nil
-		}
-		char = t.input[t.cursor]
+	char, size := utf8.DecodeRuneInString(t.input[t.cursor:])
+	if char == utf8.RuneError && size == 1 {
+		return Token{}, fmt.Errorf("invalid UTF-8 byte 0x%02X", t.input[t.cursor])
 	}
 
 	switch {
@@ -324,23 +606,46 @@ func (t *Tokenizer) Next() (Token, error) {
 	case char == ']':
 		t.cursor++
 		return NewRightBracket(), nil
+	case char == '+' && t.Peek() == '=':
+		t.cursor += 2
+		return NewPlusEqual(), nil
 	case char == '+':
 		t.cursor++
 		return NewPlus(), nil
+	case char == '-' && t.Peek() == '=':
+		t.cursor += 2
+		return NewMinusEqual(), nil
 	case char == '-':
 		t.cursor++
 		return NewMinus(), nil
+	case char == '*' && t.Peek() == '*':
+		t.cursor += 2
+		return NewPow(), nil
+	case char == '*' && t.Peek() == '=':
+		t.cursor += 2
+		return NewMultEqual(), nil
 	case char == '*':
 		t.cursor++
 		return NewMult(), nil
+	case char == '/' && t.Peek() == '=':
+		t.cursor += 2
+		return NewDivEqual(), nil
 	case char == '/':
 		t.cursor++
-
-		for t.Peek() != '\n' {
-			t.cursor++
-		}
-
 		return NewDiv(), nil
+	case char == '%' && t.Peek() == '=':
+		t.cursor += 2
+		return NewModEqual(), nil
+	case char == '%':
+		t.cursor++
+		return NewMod(), nil
+	case char == '~':
+		// '//' starts a comment, so integer division is spelled '~/'
+		if t.Peek() != '/' {
+			return Token{}, fmt.Errorf("invalid token '%c', did you mean '~/'?", char)
+		}
+		t.cursor += 2
+		return NewIntDiv(), nil
 	case char == '>':
 		next := t.Peek()
 		if next == '=' {
@@ -368,33 +673,42 @@ func (t *Tokenizer) Next() (Token, error) {
 			t.cursor++
 			return NewEqual(), nil
 		}
-	case char == '"':
-		str_lit := strings.Builder{}
-		for {
+	case char == '!' && t.Peek() == '=':
+		t.cursor += 2
+		return NewBangEqual(), nil
+	case char == '!':
+		t.cursor++
+		return NewBang(), nil
+	case char == '&':
+		if t.Peek() != '&' {
+			return Token{}, fmt.Errorf("invalid token '%c', did you mean '&&'?", char)
+		}
+		t.cursor += 2
+		return NewAndAnd(), nil
+	case char == '|':
+		if t.Peek() != '|' {
 			t.cursor++
-			if t.isEnd() {
-				break
-			}
-			char = t.input[t.cursor]
-			if char == '"' {
-				t.cursor++
-				break
-			}
-            if char == '\\' {
- 				if t.cursor + 1 > len(t.input) {
-					break;
-				}
-				if t.input[t.cursor + 1] == 'n' {
-					t.cursor++
-					char = 0x0A
-				}
-			}
-
-			str_lit.WriteByte(char)
+			return NewPipe(), nil
 		}
-		return NewStrLit(str_lit.String()), nil
+		t.cursor += 2
+		return NewOrOr(), nil
+	case char == '.':
+		if t.Peek() != '.' {
+			return Token{}, fmt.Errorf("invalid token '%c', did you mean '..'?", char)
+		}
+		if t.cursor+2 < len(t.input) && t.input[t.cursor+2] == '=' {
+			t.cursor += 3
+			return NewDotDotEqual(), nil
+		}
+		t.cursor += 2
+		return NewDotDot(), nil
+	case char == '"':
+		return t.consumeString(false)
+	case char == 'r' && t.Peek() == '"':
+		t.cursor++
+		return t.consumeString(true)
 
-	case unicode.IsLetter(rune(char)), char == '_':
+	case isIdentifierStart(char):
 		id := t.consumeIdentifier()
 		typ, ok := KEYWORDS[id]
 		if ok {
@@ -405,22 +719,257 @@ func (t *Tokenizer) Next() (Token, error) {
 			return tok, nil
 		}
 		return NewID(id), nil
-	case unicode.IsDigit(rune(char)):
+	case '0' <= char && char <= '9':
 		n, err := t.consumeNumber()
 		if err != nil {
-			return Token{}, fmt.Errorf("next: %s", err)
+			return Token{}, err
 		}
 		return NewNumber(n), nil
 	case char == ',':
 		t.cursor++
 		return NewComma(), nil
+	case char == ':':
+		t.cursor++
+		return NewColon(), nil
 	case char == ';':
 		t.cursor++
 		return NewSemiColon(), nil
 	default:
-		return Token{}, fmt.Errorf("next: invalid token '%c'", char)
+		return Token{}, fmt.Errorf("invalid token '%c'", char)
 	}
-	panic("unreachable")
+}
+
+// consumeString scans a string literal, either "..." or the multiline
+// """...""". A string with interpolations, like "a{x}b{y}c", is scanned
+// into a STR_HEAD "a", the tokens of x, a STR_MIDDLE "b", the tokens of y
+// and a STR_TAIL "c". A '{{' is a literal '{'.
+// Raw strings, r"..." or r"""...""", have no escapes nor interpolations.
+func (t *Tokenizer) consumeString(raw bool) (Token, error) {
+	quote := `"`
+	if strings.HasPrefix(t.input[t.cursor:], `"""`) {
+		quote = `"""`
+	}
+	t.cursor += len(quote)
+	// a line break right after the opening quotes is not part of the string
+	if quote == `"""` {
+		if strings.HasPrefix(t.input[t.cursor:], "\r\n") {
+			t.cursor += 2
+		} else if strings.HasPrefix(t.input[t.cursor:], "\n") {
+			t.cursor++
+		}
+	}
+
+	tokens := []Token{}
+	str_lit := strings.Builder{}
+	// position of the '}' closing the last interpolation
+	var closing_pos Pos
+	// the first invalid escape, reported once the whole literal is scanned
+	var escape_err error
+	terminated := false
+	for !t.isEnd() {
+		if strings.HasPrefix(t.input[t.cursor:], quote) {
+			t.cursor += len(quote)
+			terminated = true
+			break
+		}
+		char := t.input[t.cursor]
+		if char == '\n' && quote == `"` {
+			break
+		}
+		if char == '\\' && !raw {
+			r, err := t.consumeEscape()
+			if err != nil && escape_err == nil {
+				escape_err = err
+			}
+			str_lit.WriteRune(r)
+			continue
+		}
+		if char == '{' && t.Peek() == '{' && !raw {
+			t.cursor += 2
+			str_lit.WriteByte('{')
+			continue
+		}
+		if char == '{' && !raw {
+			part := NewStrHead(str_lit.String())
+			if len(tokens) > 0 {
+				part = NewStrMiddle(str_lit.String())
+				part.Pos = closing_pos
+			}
+			tokens = append(tokens, part)
+			str_lit.Reset()
+
+			opening_pos := t.Position(t.cursor)
+			t.cursor++
+			expr, err := t.consumeInterpolation(opening_pos)
+			if err != nil {
+				t.skipString(quote)
+				return Token{}, err
+			}
+			tokens = append(tokens, expr...)
+			closing_pos = t.Position(t.cursor - 1)
+			continue
+		}
+		str_lit.WriteByte(char)
+		t.cursor++
+	}
+
+	if !terminated {
+		if quote == `"` {
+			return Token{}, fmt.Errorf("unterminated string literal, use \"\"\" for a multiline string")
+		}
+		return Token{}, fmt.Errorf("unterminated multiline string literal")
+	}
+	if escape_err != nil {
+		return Token{}, escape_err
+	}
+
+	if len(tokens) == 0 {
+		return NewStrLit(str_lit.String()), nil
+	}
+	tail := NewStrTail(str_lit.String())
+	tail.Pos = closing_pos
+	t.pending = append(tokens[1:], tail)
+	return tokens[0], nil
+}
+
+// skipString skips the rest of a string literal after an error in one of
+// its interpolations, so the scanning goes on after its closing quote.
+func (t *Tokenizer) skipString(quote string) {
+	for !t.isEnd() && !strings.HasPrefix(t.input[t.cursor:], quote) {
+		if t.input[t.cursor] == '\n' && quote == `"` {
+			return
+		}
+		if t.input[t.cursor] == '\\' {
+			t.cursor++
+		}
+		t.cursor++
+	}
+	t.cursor = min(t.cursor+len(quote), len(t.input))
+}
+
+// ESCAPES are the escape sequences of a single character.
+var ESCAPES = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+	'0':  0,
+}
+
+// consumeEscape scans an escape sequence starting at its backslash,
+// returning the character it stands for.
+// Besides ESCAPES, there are '\xHH' for ASCII characters
+// and '\u{H...}' for any unicode code point.
+func (t *Tokenizer) consumeEscape() (rune, error) {
+	start := t.cursor
+	t.cursor++
+	if t.isEnd() {
+		return 0, ParseError{pos: t.Position(start), msg: "unterminated escape sequence"}
+	}
+	char, size := utf8.DecodeRuneInString(t.input[t.cursor:])
+	t.cursor += size
+	if r, ok := ESCAPES[char]; ok {
+		return r, nil
+	}
+
+	switch char {
+	case 'x':
+		hex := t.input[t.cursor:min(t.cursor+2, len(t.input))]
+		n, err := strconv.ParseUint(hex, 16, 8)
+		if err != nil || len(hex) != 2 || n > 0x7F {
+			return 0, ParseError{pos: t.Position(start), msg: "invalid escape sequence: '\\x' expects two hex digits, from 00 to 7F"}
+		}
+		t.cursor += 2
+		return rune(n), nil
+	case 'u':
+		// only the hex digits are read, so a missing '}' cannot
+		// make the escape run past the end of the string
+		if t.current() != '{' {
+			return 0, ParseError{pos: t.Position(start), msg: "invalid escape sequence: '\\u' expects 1 to 6 hex digits in braces, like '\\u{1F600}'"}
+		}
+		t.cursor++
+		digits_start := t.cursor
+		for !t.isEnd() && t.cursor-digits_start < 6 && strings.ContainsRune("0123456789abcdefABCDEF", rune(t.current())) {
+			t.cursor++
+		}
+		hex := t.input[digits_start:t.cursor]
+		if hex == "" || t.current() != '}' {
+			return 0, ParseError{pos: t.Position(start), msg: "invalid escape sequence: '\\u' expects 1 to 6 hex digits in braces, like '\\u{1F600}'"}
+		}
+		t.cursor++
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return 0, ParseError{pos: t.Position(start), msg: fmt.Sprintf("invalid escape sequence: '\\u{%s}' is not a unicode code point", hex)}
+		}
+		return rune(n), nil
+	}
+	return 0, ParseError{pos: t.Position(start), msg: fmt.Sprintf("unknown escape sequence '\\%c'", char)}
+}
+
+// consumeInterpolation scans the tokens of an interpolated expression,
+// up to the '}' that closes it.
+func (t *Tokenizer) consumeInterpolation(opening_pos Pos) ([]Token, error) {
+	tokens := []Token{}
+	depth := 0
+	for {
+		tok, err := t.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok.Type {
+		case EOF:
+			return nil, ParseError{pos: opening_pos, msg: "unterminated string interpolation, expected '}'"}
+		case LEFT_CURLY:
+			depth++
+		case RIGHT_CURLY:
+			if depth == 0 {
+				return tokens, nil
+			}
+			depth--
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// skipWhitespaceAndComments drops everything that is not part of a token:
+// whitespace, '//' line comments and '/* */' block comments,
+// which may be nested.
+func (t *Tokenizer) skipWhitespaceAndComments() error {
+	for !t.isEnd() {
+		char, size := utf8.DecodeRuneInString(t.input[t.cursor:])
+		switch {
+		case unicode.IsSpace(char):
+			t.cursor += size
+		case char == '/' && t.Peek() == '/':
+			for !t.isEnd() && t.input[t.cursor] != '\n' {
+				t.cursor++
+			}
+		case char == '/' && t.Peek() == '*':
+			start := t.cursor
+			depth := 0
+			for {
+				if t.isEnd() {
+					return ParseError{pos: t.Position(start), msg: "unterminated block comment"}
+				}
+				if t.input[t.cursor] == '/' && t.Peek() == '*' {
+					depth++
+					t.cursor += 2
+				} else if t.input[t.cursor] == '*' && t.Peek() == '/' {
+					depth--
+					t.cursor += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					t.cursor++
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
 }
 
 func (t *Tokenizer) Peek() byte {
@@ -434,72 +983,166 @@ func (t *Tokenizer) isEnd() bool {
 	return t.cursor >= len(t.input)
 }
 
-func (t *Tokenizer) validIdentifierChars() string {
-	return "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_0123456789"
+// isIdentifierStart follows the XID_Start property of Unicode,
+// besides '_', so identifiers can be written in any language.
+func isIdentifierStart(char rune) bool {
+	return char == '_' ||
+		unicode.IsLetter(char) ||
+		unicode.Is(unicode.Nl, char) ||
+		unicode.Is(unicode.Other_ID_Start, char)
+}
+
+// isIdentifierContinue follows the XID_Continue property of Unicode,
+// which adds digits and combining marks to isIdentifierStart.
+func isIdentifierContinue(char rune) bool {
+	return isIdentifierStart(char) ||
+		unicode.In(char, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue)
 }
 
 func (t *Tokenizer) consumeIdentifier() string {
-	out := strings.Builder{}
-	for {
-		if t.isEnd() {
-			break
-		}
-		char := t.input[t.cursor]
-		if strings.ContainsRune(t.validIdentifierChars(), rune(char)) {
-			out.WriteByte(char)
-			t.cursor++
-		} else {
+	start := t.cursor
+	for !t.isEnd() {
+		char, size := utf8.DecodeRuneInString(t.input[t.cursor:])
+		if !isIdentifierContinue(char) {
 			break
 		}
+		t.cursor += size
 	}
-	return out.String()
+	return t.input[start:t.cursor]
 }
 
+// consumeNumber scans a number literal, either a decimal like 1_000,
+// 1.5 or 1e-9, or an integer with a base prefix, like 0x1F, 0b101 or 0o17.
+// The literal is kept as written, underscores included, for exprNumber to parse.
 func (t *Tokenizer) consumeNumber() (string, error) {
-	out := strings.Builder{}
-	has_dot := false
-loop:
-	for {
-		if t.isEnd() {
-			break
+	start := t.cursor
+	if t.current() == '0' {
+		switch t.Peek() {
+		case 'x', 'X':
+			return t.consumeBaseNumber(start, "hexadecimal", "0123456789abcdefABCDEF")
+		case 'b', 'B':
+			return t.consumeBaseNumber(start, "binary", "01")
+		case 'o', 'O':
+			return t.consumeBaseNumber(start, "octal", "01234567")
 		}
+	}
 
-		char := t.input[t.cursor]
-		switch {
-		case unicode.IsDigit(rune(char)):
-			out.WriteByte(char)
+	_, err := t.consumeDigits("0123456789")
+	if err != nil {
+		return "", err
+	}
+	// a '..' after the number is a range, like 1..10
+	if t.current() == '.' && t.Peek() != '.' {
+		t.cursor++
+		_, err = t.consumeDigits("0123456789")
+		if err != nil {
+			return "", err
+		}
+		if t.current() == '.' && t.Peek() != '.' {
+			return "", t.numberError("invalid number: multiple decimal points")
+		}
+	}
+	if t.current() == 'e' || t.current() == 'E' {
+		t.cursor++
+		if t.current() == '+' || t.current() == '-' {
 			t.cursor++
-		case char == '.':
-			{
-				if !has_dot {
-					out.WriteByte(char)
-					t.cursor++
-				} else {
-					return "", fmt.Errorf("consume number: invalid number '%s': multiple decimal points", out.String())
-				}
+		}
+		count, err := t.consumeDigits("0123456789")
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return "", t.numberError("invalid number: missing digits in the exponent")
+		}
+	}
+	return t.input[start:t.cursor], t.numberEnd("decimal")
+}
+
+// consumeBaseNumber scans an integer literal after its base prefix.
+func (t *Tokenizer) consumeBaseNumber(start int, base string, digits string) (string, error) {
+	t.cursor += 2
+	count, err := t.consumeDigits(digits)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", t.numberError(fmt.Sprintf("invalid number: missing digits in %s number", base))
+	}
+	return t.input[start:t.cursor], t.numberEnd(base)
+}
+
+// consumeDigits consumes the given digits and the underscores
+// separating them, returning how many digits there were.
+// An underscore must be between two digits, so '1__0', '1_' or '0x_1' are errors.
+func (t *Tokenizer) consumeDigits(digits string) (int, error) {
+	count := 0
+	for !t.isEnd() {
+		char := t.current()
+		if char == '_' {
+			if count == 0 || !strings.ContainsRune(digits, rune(t.Peek())) {
+				return count, t.numberError("invalid number: '_' must separate digits")
 			}
-		case char == '_':
 			t.cursor++
-		default:
-			break loop
+			continue
+		}
+		if !strings.ContainsRune(digits, rune(char)) {
+			break
+		}
+		count++
+		t.cursor++
+	}
+	return count, nil
+}
+
+// numberEnd checks that a number is not followed by letters or digits,
+// like the 'z' in '12z' or the '2' in '0b12'.
+func (t *Tokenizer) numberEnd(base string) error {
+	char, _ := utf8.DecodeRuneInString(t.input[t.cursor:])
+	if !t.isEnd() && isIdentifierContinue(char) {
+		return t.numberError(fmt.Sprintf("invalid character '%c' in %s number", char, base))
+	}
+	return nil
+}
+
+// numberError positions the error at the cursor, skipping the rest
+// of the invalid number so the scanning goes on after it.
+func (t *Tokenizer) numberError(msg string) error {
+	err := ParseError{pos: t.Position(t.cursor), msg: msg}
+	for !t.isEnd() {
+		char, size := utf8.DecodeRuneInString(t.input[t.cursor:])
+		if !isIdentifierContinue(char) && (char != '.' || t.Peek() == '.') {
+			break
 		}
+		t.cursor += size
+	}
+	return err
+}
+
+func (t *Tokenizer) current() byte {
+	if t.isEnd() {
+		return 0
 	}
-	return out.String(), nil
+	return t.input[t.cursor]
 }
 
+// Scan tokenizes the whole input.
+// Scanning goes on after an invalid token, and every error found is returned joined together.
 func (t *Tokenizer) Scan() ([]Token, error) {
 	tokens := []Token{}
-	tok, err := t.Next()
-	if err != nil {
-		return nil, fmt.Errorf("scan: %s", err)
-	}
-	tokens = append(tokens, tok)
-	for tok.Type != EOF {
-		tok, err = t.Next()
+	errs := []error{}
+	for {
+		tok, err := t.Next()
 		if err != nil {
-			return nil, fmt.Errorf("scan: %s\n", err)
+			errs = append(errs, err)
+			continue
 		}
 		tokens = append(tokens, tok)
+		if tok.Type == EOF {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 	return tokens, nil
 }